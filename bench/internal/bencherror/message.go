@@ -5,11 +5,36 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // NOTE: Goのhttp.Clientがcontext.DeadlineExceededをラップして返してくれないので、暫定対応
 var ErrTimeout = errors.New("タイムアウトによりリクエスト失敗")
 
+// NOTE: 429はアプリケーションが意図して返すものなので減点せず、シナリオ側でRetry-Afterに従って待機させる
+var ErrRateLimited = errors.New("レートリミットによりリクエスト拒否")
+
+// RateLimitedError は、429 Too Many Requestsでリクエストが拒否されたことを表す
+type RateLimitedError struct {
+	Endpoint   string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("[レートリミット] %s へのリクエストが拒否されました (retry after:%s)", e.Endpoint, e.RetryAfter)
+}
+
+func (e *RateLimitedError) Unwrap() error {
+	return ErrRateLimited
+}
+
+func NewRateLimitedError(req *http.Request, retryAfter time.Duration) error {
+	return &RateLimitedError{
+		Endpoint:   fmt.Sprintf("%s %s", req.Method, req.URL.EscapedPath()),
+		RetryAfter: retryAfter,
+	}
+}
+
 // ベンチマーカー本体由来のエラー
 
 func NewInternalError(err error) error {
@@ -2,12 +2,17 @@ package isupipe
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/isucon/isucandar/agent"
 	"github.com/isucon/isucon13/bench/internal/bencherror"
@@ -166,3 +171,62 @@ func sendRequest(ctx context.Context, agent *agent.Agent, req *http.Request) (*h
 
 	return resp, nil
 }
+
+// parseNextCursor は、Linkヘッダのrel="next"から次ページのカーソルを取り出す
+// 次ページが存在しない場合は空文字を返す
+func parseNextCursor(resp *http.Response) string {
+	for _, link := range strings.Split(resp.Header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) != `rel="next"` {
+			continue
+		}
+
+		rawURL := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return ""
+		}
+		query := u.Query()
+		if before := query.Get("before"); before != "" {
+			return before
+		}
+		return query.Get("after")
+	}
+
+	return ""
+}
+
+// デフォルトの待機時間 (Retry-Afterが無い、もしくは解釈できない場合)
+const defaultRetryAfter = 1 * time.Second
+
+// parseRetryAfter は、Retry-Afterヘッダ (秒数またはHTTP-date) から待機時間を取り出す
+func parseRetryAfter(resp *http.Response) time.Duration {
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" {
+		return defaultRetryAfter
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// newIdempotencyKey は、リクエストごとに一意なIdempotency-Keyを生成する
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -85,6 +85,17 @@ func (c *Client) GetLivecomments(ctx context.Context, livestreamID int64, stream
 		req.URL.RawQuery = query.Encode()
 	}
 
+	if o.cursorParam != nil {
+		query := req.URL.Query()
+		if o.cursorParam.Before != "" {
+			query.Add("before", o.cursorParam.Before)
+		}
+		if o.cursorParam.After != "" {
+			query.Add("after", o.cursorParam.After)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
 	resp, err := sendRequest(ctx, c.themeAgent, req)
 	if err != nil {
 		return nil, err
@@ -98,6 +109,10 @@ func (c *Client) GetLivecomments(ctx context.Context, livestreamID int64, stream
 		return nil, bencherror.NewHttpStatusError(req, o.wantStatusCode, resp.StatusCode)
 	}
 
+	if o.nextCursor != nil {
+		*o.nextCursor = parseNextCursor(resp)
+	}
+
 	livecomments := []*Livecomment{}
 	if resp.StatusCode == defaultStatusCode {
 		if err := json.NewDecoder(resp.Body).Decode(&livecomments); err != nil {
@@ -223,6 +238,15 @@ func (c *Client) PostLivecomment(ctx context.Context, livestreamID int64, stream
 	}
 	req.Header.Add("Content-Type", "application/json;charset=utf-8")
 
+	idempotencyKey := o.idempotencyKey
+	if idempotencyKey == "" {
+		idempotencyKey, err = newIdempotencyKey()
+		if err != nil {
+			return nil, 0, bencherror.NewInternalError(err)
+		}
+	}
+	req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+
 	resp, err := sendRequest(ctx, c.themeAgent, req)
 	if err != nil {
 		return nil, 0, err
@@ -232,10 +256,18 @@ func (c *Client) PostLivecomment(ctx context.Context, livestreamID int64, stream
 		resp.Body.Close()
 	}()
 
+	if resp.StatusCode == http.StatusTooManyRequests && o.wantStatusCode != http.StatusTooManyRequests {
+		return nil, 0, bencherror.NewRateLimitedError(req, parseRetryAfter(resp))
+	}
 	if resp.StatusCode != o.wantStatusCode {
 		return nil, 0, bencherror.NewHttpStatusError(req, o.wantStatusCode, resp.StatusCode)
 	}
 
+	replayed := resp.Header.Get(idempotentReplayedHeader) == "true"
+	if o.idempotentReplayed != nil {
+		*o.idempotentReplayed = replayed
+	}
+
 	var livecommentResponse *PostLivecommentResponse
 	if resp.StatusCode == defaultStatusCode {
 		if err := json.NewDecoder(resp.Body).Decode(&livecommentResponse); err != nil {
@@ -246,7 +278,10 @@ func (c *Client) PostLivecomment(ctx context.Context, livestreamID int64, stream
 			return nil, 0, err
 		}
 
-		benchscore.AddTip(uint64(tip.Tip))
+		// 再送の結果であれば、チップは既に計上済み
+		if !replayed {
+			benchscore.AddTip(uint64(tip.Tip))
+		}
 	}
 
 	return livecommentResponse, tip.Tip, nil
@@ -30,6 +30,13 @@ func (l *Livestream) Hours() int {
 	return int(diffSec / time.Hour)
 }
 
+type (
+	RescheduleLivestreamRequest struct {
+		StartAt int64 `json:"start_at"`
+		EndAt   int64 `json:"end_at"`
+	}
+)
+
 type (
 	ReserveLivestreamRequest struct {
 		Tags         []int64 `json:"tags"`
@@ -114,6 +121,17 @@ func (c *Client) SearchLivestreams(
 		req.URL.RawQuery = query.Encode()
 	}
 
+	if o.cursorParam != nil {
+		query := req.URL.Query()
+		if o.cursorParam.Before != "" {
+			query.Add("before", o.cursorParam.Before)
+		}
+		if o.cursorParam.After != "" {
+			query.Add("after", o.cursorParam.After)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
 	resp, err := sendRequest(ctx, c.agent, req)
 	if err != nil {
 		return nil, err
@@ -127,6 +145,10 @@ func (c *Client) SearchLivestreams(
 		return nil, bencherror.NewHttpStatusError(req, o.wantStatusCode, resp.StatusCode)
 	}
 
+	if o.nextCursor != nil {
+		*o.nextCursor = parseNextCursor(resp)
+	}
+
 	var livestreams []*Livestream
 	if resp.StatusCode == defaultStatusCode {
 		if err := json.NewDecoder(resp.Body).Decode(&livestreams); err != nil {
@@ -266,6 +288,87 @@ func (c *Client) ReserveLivestream(ctx context.Context, streamerName string, r *
 	return livestream, nil
 }
 
+// 配信予約のキャンセル
+func (c *Client) CancelLivestream(ctx context.Context, livestreamID int64, streamerName string, opts ...ClientOption) error {
+	var (
+		defaultStatusCode = http.StatusNoContent
+		o                 = newClientOptions(defaultStatusCode, opts...)
+	)
+
+	if err := c.setStreamerURL(streamerName); err != nil {
+		return bencherror.NewInternalError(err)
+	}
+	urlPath := fmt.Sprintf("/api/livestream/%d", livestreamID)
+	req, err := c.themeAgent.NewRequest(http.MethodDelete, urlPath, nil)
+	if err != nil {
+		return bencherror.NewInternalError(err)
+	}
+
+	resp, err := sendRequest(ctx, c.themeAgent, req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != o.wantStatusCode {
+		return bencherror.NewHttpStatusError(req, o.wantStatusCode, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// 配信予約の日時変更
+func (c *Client) RescheduleLivestream(ctx context.Context, livestreamID int64, streamerName string, r *RescheduleLivestreamRequest, opts ...ClientOption) (*Livestream, error) {
+	var (
+		defaultStatusCode = http.StatusOK
+		o                 = newClientOptions(defaultStatusCode, opts...)
+	)
+
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, bencherror.NewInternalError(err)
+	}
+
+	if err := c.setStreamerURL(streamerName); err != nil {
+		return nil, bencherror.NewInternalError(err)
+	}
+	urlPath := fmt.Sprintf("/api/livestream/%d/schedule", livestreamID)
+	req, err := c.themeAgent.NewRequest(http.MethodPut, urlPath, bytes.NewReader(payload))
+	if err != nil {
+		return nil, bencherror.NewInternalError(err)
+	}
+	req.Header.Add("Content-Type", "application/json;charset=utf-8")
+
+	resp, err := sendRequest(ctx, c.themeAgent, req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != o.wantStatusCode {
+		return nil, bencherror.NewHttpStatusError(req, o.wantStatusCode, resp.StatusCode)
+	}
+
+	var livestream *Livestream
+	if resp.StatusCode == defaultStatusCode {
+		if err := json.NewDecoder(resp.Body).Decode(&livestream); err != nil {
+			return nil, bencherror.NewHttpResponseError(err, req)
+		}
+
+		if err := ValidateResponse(req, livestream); err != nil {
+			return nil, err
+		}
+	}
+
+	return livestream, nil
+}
+
 func (c *Client) EnterLivestream(ctx context.Context, livestreamID int64, streamerName string, opts ...ClientOption) error {
 	var (
 		defaultStatusCode = http.StatusOK
@@ -6,6 +6,13 @@ type LimitParam struct {
 	Limit int
 }
 
+// CursorParam は、一覧APIのページングに用いるカーソル
+// Before, Afterのどちらか一方のみ指定する
+type CursorParam struct {
+	Before string
+	After  string
+}
+
 type SearchTagParam struct {
 	Tag string
 }
@@ -13,11 +20,18 @@ type SearchTagParam struct {
 type ClientOptions struct {
 	wantStatusCode int
 	limitParam     *LimitParam
+	cursorParam    *CursorParam
 	searchTag      *SearchTagParam
 	eTag           string
+	// nextCursor は、レスポンスのLinkヘッダから得た次ページのカーソルの格納先
+	nextCursor *string
 	// NOTE: スパム報告は、ベンチ走行中は粛清されたライブコメントを期待する場合が有り、エラーになることがある
 	// Pretestでのみスパム報告のバリデーションを行うための対応
 	validateReportLivecomment bool
+	// idempotencyKey は、Idempotency-Keyヘッダに指定するキー (空の場合はリクエストごとに生成する)
+	idempotencyKey string
+	// idempotentReplayed は、レスポンスが同じIdempotency-Keyに対する再送の結果であるかの格納先
+	idempotentReplayed *bool
 }
 
 func newClientOptions(defaultStatusCode int, opts ...ClientOption) *ClientOptions {
@@ -47,6 +61,30 @@ func WithLimitQueryParam(limit int) ClientOption {
 	}
 }
 
+func WithBeforeCursorQueryParam(cursor string) ClientOption {
+	return func(o *ClientOptions) {
+		o.cursorParam = &CursorParam{
+			Before: cursor,
+		}
+	}
+}
+
+func WithAfterCursorQueryParam(cursor string) ClientOption {
+	return func(o *ClientOptions) {
+		o.cursorParam = &CursorParam{
+			After: cursor,
+		}
+	}
+}
+
+// WithNextCursor は、次ページのカーソルをnextに格納します
+// 次ページが存在しない場合は空文字が格納されます
+func WithNextCursor(next *string) ClientOption {
+	return func(o *ClientOptions) {
+		o.nextCursor = next
+	}
+}
+
 func WithSearchTagQueryParam(tag string) ClientOption {
 	return func(o *ClientOptions) {
 		o.searchTag = &SearchTagParam{
@@ -66,3 +104,18 @@ func WithValidateReportLivecomment() ClientOption {
 		o.validateReportLivecomment = true
 	}
 }
+
+// WithIdempotencyKey は、Idempotency-Keyヘッダに指定するキーを固定します
+// 同じキーでリクエストし直すと、最初のレスポンスが返されることが期待されます
+func WithIdempotencyKey(key string) ClientOption {
+	return func(o *ClientOptions) {
+		o.idempotencyKey = key
+	}
+}
+
+// WithIdempotentReplayed は、レスポンスが再送の結果(Idempotent-Replayedヘッダ付き)であるかをreplayedに格納します
+func WithIdempotentReplayed(replayed *bool) ClientOption {
+	return func(o *ClientOptions) {
+		o.idempotentReplayed = replayed
+	}
+}
@@ -45,6 +45,17 @@ func (c *Client) GetReactions(ctx context.Context, livestreamID int64, streamerN
 		req.URL.RawQuery = query.Encode()
 	}
 
+	if o.cursorParam != nil {
+		query := req.URL.Query()
+		if o.cursorParam.Before != "" {
+			query.Add("before", o.cursorParam.Before)
+		}
+		if o.cursorParam.After != "" {
+			query.Add("after", o.cursorParam.After)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
 	resp, err := sendRequest(ctx, c.themeAgent, req)
 	if err != nil {
 		return nil, err
@@ -58,6 +69,10 @@ func (c *Client) GetReactions(ctx context.Context, livestreamID int64, streamerN
 		return nil, bencherror.NewHttpStatusError(req, o.wantStatusCode, resp.StatusCode)
 	}
 
+	if o.nextCursor != nil {
+		*o.nextCursor = parseNextCursor(resp)
+	}
+
 	reactions := []Reaction{}
 	if resp.StatusCode == defaultStatusCode {
 		if err := json.NewDecoder(resp.Body).Decode(&reactions); err != nil {
@@ -102,6 +117,9 @@ func (c *Client) PostReaction(ctx context.Context, livestreamID int64, streamerN
 		resp.Body.Close()
 	}()
 
+	if resp.StatusCode == http.StatusTooManyRequests && o.wantStatusCode != http.StatusTooManyRequests {
+		return nil, bencherror.NewRateLimitedError(req, parseRetryAfter(resp))
+	}
 	if resp.StatusCode != o.wantStatusCode {
 		return nil, bencherror.NewHttpStatusError(req, o.wantStatusCode, resp.StatusCode)
 	}
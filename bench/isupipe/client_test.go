@@ -36,3 +36,31 @@ func TestClient_Timeout(t *testing.T) {
 	_, err = client.GetTags(ctx)
 	assert.True(t, errors.Is(err, bencherror.ErrTimeout))
 }
+
+func TestParseNextCursor(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	assert.Equal(t, "", parseNextCursor(resp))
+
+	resp.Header.Set("Link", `</api/livestream/1/livecomment?before=abc&limit=10>; rel="next"`)
+	assert.Equal(t, "abc", parseNextCursor(resp))
+
+	resp.Header.Set("Link", `</api/livestream/1/livecomment?after=def&limit=10>; rel="next"`)
+	assert.Equal(t, "def", parseNextCursor(resp))
+
+	resp.Header.Set("Link", `</api/livestream/1/livecomment?before=abc>; rel="prev"`)
+	assert.Equal(t, "", parseNextCursor(resp))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter(resp))
+
+	resp.Header.Set("Retry-After", "3")
+	assert.Equal(t, 3*time.Second, parseRetryAfter(resp))
+
+	resp.Header.Set("Retry-After", "invalid")
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter(resp))
+
+	resp.Header.Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(resp))
+}
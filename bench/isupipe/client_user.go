@@ -38,6 +38,18 @@ type (
 	}
 )
 
+// UpdateMeRequest は、プロフィール更新のリクエスト
+// nilのフィールドは更新されません
+type UpdateMeRequest struct {
+	DisplayName *string             `json:"display_name,omitempty"`
+	Description *string             `json:"description,omitempty"`
+	Theme       *UpdateThemeRequest `json:"theme,omitempty"`
+}
+
+type UpdateThemeRequest struct {
+	DarkMode *bool `json:"dark_mode,omitempty"`
+}
+
 type Theme struct {
 	DarkMode bool `json:"dark_mode"`
 }
@@ -256,6 +268,50 @@ func (c *Client) GetMe(ctx context.Context, opts ...ClientOption) (*User, error)
 	return user, nil
 }
 
+func (c *Client) UpdateMe(ctx context.Context, r *UpdateMeRequest, opts ...ClientOption) (*User, error) {
+	var (
+		defaultStatusCode = http.StatusOK
+		o                 = newClientOptions(defaultStatusCode, opts...)
+	)
+
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, bencherror.NewInternalError(err)
+	}
+
+	req, err := c.agent.NewRequest(http.MethodPatch, "/api/user/me", bytes.NewReader(payload))
+	if err != nil {
+		return nil, bencherror.NewInternalError(err)
+	}
+	req.Header.Add("Content-Type", "application/json;charset=utf-8")
+
+	resp, err := sendRequest(ctx, c.agent, req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != o.wantStatusCode {
+		return nil, bencherror.NewHttpStatusError(req, o.wantStatusCode, resp.StatusCode)
+	}
+
+	var user *User
+	if resp.StatusCode == defaultStatusCode {
+		if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+			return nil, bencherror.NewHttpResponseError(err, req)
+		}
+
+		if err := ValidateResponse(req, user); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
 func (c *Client) Register(ctx context.Context, r *RegisterRequest, opts ...ClientOption) (*User, error) {
 	var (
 		defaultStatusCode = http.StatusCreated
@@ -58,6 +58,29 @@ func TestClientUser_Login(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, streamer.DarkMode, theme.DarkMode)
 
+	// プロフィール・テーマ更新
+	var (
+		newDisplayName = streamer.DisplayName + "-renamed"
+		newDarkMode    = !streamer.DarkMode
+	)
+	updatedUser, err := client.UpdateMe(ctx, &UpdateMeRequest{
+		DisplayName: &newDisplayName,
+		Theme: &UpdateThemeRequest{
+			DarkMode: &newDarkMode,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, newDisplayName, updatedUser.DisplayName)
+	assert.Equal(t, streamer.Description, updatedUser.Description)
+
+	user, err = client.GetMe(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, newDisplayName, user.DisplayName)
+
+	theme, err = client.GetStreamerTheme(ctx, user)
+	assert.NoError(t, err)
+	assert.Equal(t, newDarkMode, theme.DarkMode)
+
 	// アイコンアップロード・取得
 	img := scheduler.IconSched.GetRandomIcon()
 	postIconResp, err := client.PostIcon(ctx, &PostIconRequest{
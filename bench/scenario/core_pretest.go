@@ -102,6 +102,9 @@ func Pretest(ctx context.Context, contestantLogger *zap.Logger, dnsResolver *res
 	if err := NormalPostLivecommentPretest(ctx, contestantLogger, testUser, dnsResolver); err != nil {
 		return err
 	}
+	if err := NormalIdempotentLivecommentPretest(ctx, contestantLogger, testUser, dnsResolver); err != nil {
+		return err
+	}
 	if err := NormalModerateLivecommentPretest(ctx, contestantLogger, testUser, dnsResolver); err != nil {
 		return err
 	}
@@ -150,7 +150,7 @@ func normalStatsCalcPretest(ctx context.Context, contestantLogger *zap.Logger, d
 	reactionCount := 1 + statsCalcRandSource.Intn(3)
 	for r := 0; r < reactionCount; r++ {
 		reaction := scheduler.GetReaction()
-		if _, err := viewerClient.PostReaction(ctx, livestream.ID, livestream.Owner.Name, &isupipe.PostReactionRequest{
+		if _, err := postReactionWithRetry(ctx, viewerClient, livestream.ID, livestream.Owner.Name, &isupipe.PostReactionRequest{
 			EmojiName: reaction,
 		}); err != nil {
 			return err
@@ -164,7 +164,7 @@ func normalStatsCalcPretest(ctx context.Context, contestantLogger *zap.Logger, d
 	for l := 0; l < livecommentCount; l++ {
 		livecomment := scheduler.LivecommentScheduler.GetLongPositiveComment()
 		tip := &scheduler.Tip{Tip: rand.Intn(10)}
-		resp, _, err := viewerClient.PostLivecomment(ctx, livestream.ID, livestream.Owner.Name, livecomment.Comment, tip)
+		resp, _, err := postLivecommentWithRetry(ctx, viewerClient, livestream.ID, livestream.Owner.Name, livecomment.Comment, tip)
 		if err != nil {
 			return err
 		}
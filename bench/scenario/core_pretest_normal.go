@@ -7,6 +7,7 @@ import (
 	_ "embed"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"reflect"
 	"slices"
 	"strings"
@@ -616,7 +617,7 @@ func NormalPostLivecommentPretest(ctx context.Context, contestantLogger *zap.Log
 	}
 
 	notip := &scheduler.Tip{}
-	postedLiveComment, _, err := client.PostLivecomment(ctx, livestream.ID, livestream.Owner.Name, "test", notip)
+	postedLiveComment, _, err := postLivecommentWithRetry(ctx, client, livestream.ID, livestream.Owner.Name, "test", notip)
 	if err != nil {
 		return err
 	}
@@ -660,6 +661,72 @@ func NormalPostLivecommentPretest(ctx context.Context, contestantLogger *zap.Log
 	return nil
 }
 
+// NormalIdempotentLivecommentPretest は、同じIdempotency-Keyでのライブコメント投稿が二重に処理されないことを確認する
+func NormalIdempotentLivecommentPretest(ctx context.Context, contestantLogger *zap.Logger, testUser *isupipe.User, dnsResolver *resolver.DNSResolver) error {
+	client, err := isupipe.NewCustomResolverClient(
+		contestantLogger,
+		dnsResolver,
+		agent.WithTimeout(config.PretestTimeout),
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Login(ctx, &isupipe.LoginRequest{
+		Username: testUser.Name,
+		Password: defaultPasswordOrPretest(testUser.Name),
+	}); err != nil {
+		return err
+	}
+
+	livestreams, err := client.GetMyLivestreams(ctx)
+	if err != nil {
+		return err
+	}
+	if len(livestreams) == 0 {
+		return fmt.Errorf("自分のライブ配信が存在しません")
+	}
+	livestream := livestreams[rand.Intn(len(livestreams))] // ランダムに選ぶ
+
+	idempotencyKey := fmt.Sprintf("pretest-%d-%d", livestream.ID, time.Now().UnixNano())
+	tip := &scheduler.Tip{Tip: 10}
+	var replayed bool
+	posted, _, err := postLivecommentWithRetry(ctx, client, livestream.ID, livestream.Owner.Name, "idempotent", tip, isupipe.WithIdempotencyKey(idempotencyKey), isupipe.WithIdempotentReplayed(&replayed))
+	if err != nil {
+		return err
+	}
+	if replayed {
+		return fmt.Errorf("初めて使うIdempotency-Keyでのライブコメント投稿が、再送として扱われました")
+	}
+
+	// 同じキーでリトライすると、最初のレスポンスが返される
+	replayedComment, _, err := postLivecommentWithRetry(ctx, client, livestream.ID, livestream.Owner.Name, "idempotent", tip, isupipe.WithIdempotencyKey(idempotencyKey), isupipe.WithIdempotentReplayed(&replayed))
+	if err != nil {
+		return err
+	}
+	if !replayed {
+		return fmt.Errorf("同じIdempotency-Keyでのライブコメント投稿に、Idempotent-Replayedヘッダが付与されていません")
+	}
+	if replayedComment.ID != posted.ID || replayedComment.Tip != posted.Tip || replayedComment.CreatedAt != posted.CreatedAt {
+		return fmt.Errorf("同じIdempotency-Keyでのライブコメント投稿で、最初のレスポンスが返されませんでした expected:%d actual:%d", posted.ID, replayedComment.ID)
+	}
+
+	livecomments, err := client.GetLivecomments(ctx, livestream.ID, livestream.Owner.Name, isupipe.WithLimitQueryParam(1))
+	if err != nil {
+		return err
+	}
+	if len(livecomments) == 0 || livecomments[0].ID != posted.ID {
+		return fmt.Errorf("同じIdempotency-Keyでのライブコメント投稿が、二重に登録されています")
+	}
+
+	// 同じキーで異なる内容を投稿すると拒否される
+	if _, _, err := postLivecommentWithRetry(ctx, client, livestream.ID, livestream.Owner.Name, "different", tip, isupipe.WithIdempotencyKey(idempotencyKey), isupipe.WithStatusCode(http.StatusUnprocessableEntity)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func NormalReactionPretest(ctx context.Context, contestantLogger *zap.Logger, testUser *isupipe.User, dnsResolver *resolver.DNSResolver) error {
 	// 投稿したリアクションがGETできるか
 	// limitをつけられるか
@@ -693,7 +760,7 @@ func NormalReactionPretest(ctx context.Context, contestantLogger *zap.Logger, te
 			return err
 		}
 
-		if r, err := client.PostReaction(ctx, livestream.ID, livestream.Owner.Name, &isupipe.PostReactionRequest{
+		if r, err := postReactionWithRetry(ctx, client, livestream.ID, livestream.Owner.Name, &isupipe.PostReactionRequest{
 			EmojiName: "chair",
 		}); err != nil {
 			return err
@@ -908,7 +975,7 @@ func NormalModerateLivecommentPretest(ctx context.Context, contestantLogger *zap
 	for i := 0; i <= 5; i++ {
 		// spamではない普通のコメントをする
 		livecomment := scheduler.LivecommentScheduler.GetLongPositiveComment()
-		r, _, err := spammerClient.PostLivecomment(ctx, livestream.ID, livestream.Owner.Name, livecomment.Comment, &scheduler.Tip{Tip: 100})
+		r, _, err := postLivecommentWithRetry(ctx, spammerClient, livestream.ID, livestream.Owner.Name, livecomment.Comment, &scheduler.Tip{Tip: 100})
 		if err != nil {
 			return err
 		}
@@ -926,7 +993,7 @@ func NormalModerateLivecommentPretest(ctx context.Context, contestantLogger *zap
 
 	spamComment, _ := scheduler.LivecommentScheduler.GetNegativeComment()
 	notip := &scheduler.Tip{}
-	_, _, err = spammerClient.PostLivecomment(ctx, livestream.ID, livestream.Owner.Name, spamComment.Comment, notip)
+	_, _, err = postLivecommentWithRetry(ctx, spammerClient, livestream.ID, livestream.Owner.Name, spamComment.Comment, notip)
 	if err != nil {
 		return err
 	}
@@ -960,7 +1027,7 @@ func NormalModerateLivecommentPretest(ctx context.Context, contestantLogger *zap
 	}
 
 	// ngwordに登録されたので投稿できないはず
-	_, _, err = spammerClient.PostLivecomment(ctx, livestream.ID, livestream.Owner.Name, spamComment.Comment, notip)
+	_, _, err = postLivecommentWithRetry(ctx, spammerClient, livestream.ID, livestream.Owner.Name, spamComment.Comment, notip)
 	if err == nil {
 		return fmt.Errorf("ngwordに登録されたはずのライブコメントが投稿できています")
 	}
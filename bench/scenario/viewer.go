@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/isucon/isucon13/bench/internal/bencherror"
 	"github.com/isucon/isucon13/bench/internal/scheduler"
@@ -15,7 +16,7 @@ import (
 
 var (
 	basicViewerScenarioRandSourceMu sync.Mutex
-	basicViewerScenarioRandSource = rand.New(rand.NewSource(63877281473681))
+	basicViewerScenarioRandSource   = rand.New(rand.NewSource(63877281473681))
 )
 
 func BasicViewerScenario(
@@ -116,7 +117,11 @@ func BasicViewerScenario(
 			lgr.Warnf("view: failed to get tips for stream: %s\n", err.Error())
 			return err
 		}
-		if _, _, err := client.PostLivecomment(ctx, livestream.ID, livestream.Owner.Name, livecomment.Comment, tip); err != nil && !errors.Is(err, bencherror.ErrTimeout) {
+		if _, _, err := client.PostLivecomment(ctx, livestream.ID, livestream.Owner.Name, livecomment.Comment, tip); errors.Is(err, bencherror.ErrRateLimited) {
+			// レートリミット・スローモードの場合は、Retry-Afterだけ待って視聴を続ける
+			waitRetryAfter(ctx, err)
+			continue
+		} else if err != nil && !errors.Is(err, bencherror.ErrTimeout) {
 			contestantLogger.Warn("ライブコメントを配信に投稿できないため、視聴者が離脱します", zap.String("viewer", username), zap.Int64("livestream_id", livestream.ID), zap.Error(err))
 			lgr.Warnf("view: failed to post livecomment: %s\n", err.Error())
 			return err
@@ -130,7 +135,10 @@ func BasicViewerScenario(
 		emojiName := scheduler.GetReaction()
 		if _, err := client.PostReaction(ctx, livestream.ID, livestream.Owner.Name, &isupipe.PostReactionRequest{
 			EmojiName: emojiName,
-		}); err != nil {
+		}); errors.Is(err, bencherror.ErrRateLimited) {
+			waitRetryAfter(ctx, err)
+			continue
+		} else if err != nil {
 			lgr.Warnf("view: failed to post reactions: %s\n", err.Error())
 			continue
 		}
@@ -173,13 +181,13 @@ func ViewerSpamScenario(
 
 	comment, isModerated := scheduler.LivecommentScheduler.GetNegativeComment()
 	if isModerated {
-		_, _, err := viewer.PostLivecomment(ctx, livestream.ID, livestream.Owner.Name, comment.Comment, &scheduler.Tip{}, isupipe.WithStatusCode(http.StatusBadRequest))
+		_, _, err := postLivecommentWithRetry(ctx, viewer, livestream.ID, livestream.Owner.Name, comment.Comment, &scheduler.Tip{}, isupipe.WithStatusCode(http.StatusBadRequest))
 		if err != nil {
 			lgr.Warnf("viewer_spam: failed to post livecomment (moderated spam): %s\n", err.Error())
 			return err
 		}
 	} else {
-		resp, _, err := viewer.PostLivecomment(ctx, livestream.ID, livestream.Owner.Name, comment.Comment, &scheduler.Tip{})
+		resp, _, err := postLivecommentWithRetry(ctx, viewer, livestream.ID, livestream.Owner.Name, comment.Comment, &scheduler.Tip{})
 		if err != nil {
 			lgr.Warnf("viewer_spam: failed to post livecomment (non-moderated spam): %s\n", err.Error())
 			return err
@@ -222,3 +230,45 @@ func BasicViewerReportScenario(
 
 	return nil
 }
+
+// waitRetryAfter は、レートリミットエラーのRetry-Afterが経過するまで待機する
+func waitRetryAfter(ctx context.Context, err error) {
+	var rateLimitedErr *bencherror.RateLimitedError
+	if !errors.As(err, &rateLimitedErr) {
+		return
+	}
+
+	timer := time.NewTimer(rateLimitedErr.RetryAfter)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// レートリミットで拒否された場合に再試行する最大回数
+const rateLimitMaxRetries = 5
+
+// postLivecommentWithRetry は、ライブコメントを投稿する
+// レートリミットで拒否された場合は、Retry-Afterが経過するまで待ってから再試行する
+func postLivecommentWithRetry(ctx context.Context, client *isupipe.Client, livestreamID int64, streamerName string, comment string, tip *scheduler.Tip, opts ...isupipe.ClientOption) (*isupipe.PostLivecommentResponse, int, error) {
+	for attempt := 0; ; attempt++ {
+		resp, n, err := client.PostLivecomment(ctx, livestreamID, streamerName, comment, tip, opts...)
+		if !errors.Is(err, bencherror.ErrRateLimited) || attempt >= rateLimitMaxRetries || ctx.Err() != nil {
+			return resp, n, err
+		}
+		waitRetryAfter(ctx, err)
+	}
+}
+
+// postReactionWithRetry は、リアクションを投稿する
+// レートリミットで拒否された場合は、Retry-Afterが経過するまで待ってから再試行する
+func postReactionWithRetry(ctx context.Context, client *isupipe.Client, livestreamID int64, streamerName string, r *isupipe.PostReactionRequest, opts ...isupipe.ClientOption) (*isupipe.Reaction, error) {
+	for attempt := 0; ; attempt++ {
+		reaction, err := client.PostReaction(ctx, livestreamID, streamerName, r, opts...)
+		if !errors.Is(err, bencherror.ErrRateLimited) || attempt >= rateLimitMaxRetries || ctx.Err() != nil {
+			return reaction, err
+		}
+		waitRetryAfter(ctx, err)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	moderationActionBanUser     = "ban_user"
+	moderationActionTimeoutUser = "timeout_user"
+	moderationActionUnbanUser   = "unban_user"
+
+	// livecommentHiddenReasonBan は、投稿者がBANされたため非表示にされたことを表す
+	livecommentHiddenReasonBan = "ban"
+
+	// タイムアウトの最大期間
+	userTimeoutMaxDuration = 30 * 24 * time.Hour
+)
+
+// UserBanModel は、配信者のチャンネル(全ての配信)に対するユーザのBAN
+// ExpiresAtが0の場合は無期限のBAN、それ以外は期限付きのタイムアウト
+type UserBanModel struct {
+	ID           int64  `db:"id"`
+	StreamerID   int64  `db:"streamer_id"`
+	UserID       int64  `db:"user_id"`
+	LivestreamID int64  `db:"livestream_id"`
+	Reason       string `db:"reason"`
+	ExpiresAt    int64  `db:"expires_at"`
+	CreatedBy    int64  `db:"created_by"`
+	CreatedAt    int64  `db:"created_at"`
+}
+
+type UserBan struct {
+	ID   int64 `json:"id"`
+	User User  `json:"user"`
+	// LivestreamID は、BANを行った配信
+	LivestreamID int64  `json:"livestream_id"`
+	Reason       string `json:"reason"`
+	// ExpiresAt は、タイムアウトの場合のみ設定する
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	CreatedAt int64 `json:"created_at"`
+}
+
+type BanUserRequest struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+	// HidePastLivecomments がtrueの場合、ユーザが配信者の配信に投稿した過去のライブコメントを全て非表示にする
+	HidePastLivecomments bool `json:"hide_past_livecomments"`
+}
+
+type TimeoutUserRequest struct {
+	BanUserRequest
+	// DurationSeconds は、タイムアウトの期間(秒)
+	DurationSeconds int64 `json:"duration_seconds"`
+}
+
+// getActiveUserBan は、ユーザが配信者のチャンネルでBANまたはタイムアウト中であれば、そのBANを返す
+func getActiveUserBan(ctx context.Context, tx *sqlx.Tx, streamerID int64, userID int64) (*UserBanModel, error) {
+	var ban UserBanModel
+	if err := tx.GetContext(ctx, &ban, "SELECT * FROM user_bans WHERE streamer_id = ? AND user_id = ? AND (expires_at = 0 OR expires_at > ?)", streamerID, userID, time.Now().Unix()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &ban, nil
+}
+
+// verifyUserNotBanned は、ユーザが配信者のチャンネルでBANまたはタイムアウト中であれば、403を返す
+// タイムアウトの場合は、解除までの秒数をRetry-Afterヘッダに設定する
+func verifyUserNotBanned(ctx context.Context, tx *sqlx.Tx, c echo.Context, streamerID int64, userID int64) error {
+	ban, err := getActiveUserBan(ctx, tx, streamerID, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user ban: "+err.Error())
+	}
+	if ban == nil {
+		return nil
+	}
+
+	if ban.ExpiresAt == 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "you are banned from this streamer's livestreams")
+	}
+	retryAfter := ban.ExpiresAt - time.Now().Unix()
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("you are timed out from this streamer's livestreams for %d seconds", retryAfter))
+}
+
+// ユーザBAN API
+// POST /api/livestream/:livestream_id/ban
+// 配信者のみが行える
+func banUserHandler(c echo.Context) error {
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	var req *BanUserRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	return banUser(c, req, 0)
+}
+
+// ユーザタイムアウトAPI
+// POST /api/livestream/:livestream_id/timeout
+// 配信者のみが行える
+func timeoutUserHandler(c echo.Context) error {
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	var req *TimeoutUserRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	if duration <= 0 || duration > userTimeoutMaxDuration {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("duration_seconds must be between 1 and %d", int64(userTimeoutMaxDuration/time.Second)))
+	}
+	return banUser(c, &req.BanUserRequest, duration)
+}
+
+// banUser は、durationが0の場合は無期限のBAN、それ以外はタイムアウトを行う
+// 既にBANまたはタイムアウト中の場合は上書きする
+// セッションは呼び出し側で検証済みであること
+func banUser(c echo.Context, req *BanUserRequest, duration time.Duration) error {
+	ctx := c.Request().Context()
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	// BANは配信者のチャンネル全体に及ぶため、特定の配信のコラボレーターには行わせない
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	var targetModel UserModel
+	if err := tx.GetContext(ctx, &targetModel, "SELECT * FROM users WHERE name = ?", req.Username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+	isModerator, err := canModerateLivestream(ctx, tx, livestreamModel, targetModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderation permission: "+err.Error())
+	}
+	if isModerator {
+		return echo.NewHTTPError(http.StatusBadRequest, "can't ban the streamer or collaborators")
+	}
+
+	now := time.Now()
+	banModel := UserBanModel{
+		StreamerID:   livestreamModel.UserID,
+		UserID:       targetModel.ID,
+		LivestreamID: livestreamModel.ID,
+		Reason:       req.Reason,
+		CreatedBy:    userID,
+		CreatedAt:    now.Unix(),
+	}
+	action := moderationActionBanUser
+	if duration > 0 {
+		banModel.ExpiresAt = now.Add(duration).Unix()
+		action = moderationActionTimeoutUser
+	}
+	if _, err := tx.NamedExecContext(ctx, `
+	INSERT INTO user_bans (streamer_id, user_id, livestream_id, reason, expires_at, created_by, created_at)
+	VALUES (:streamer_id, :user_id, :livestream_id, :reason, :expires_at, :created_by, :created_at)
+	ON DUPLICATE KEY UPDATE livestream_id = VALUES(livestream_id), reason = VALUES(reason), expires_at = VALUES(expires_at), created_by = VALUES(created_by), created_at = VALUES(created_at)
+	`, &banModel); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert user ban: "+err.Error())
+	}
+	if err := tx.GetContext(ctx, &banModel, "SELECT * FROM user_bans WHERE streamer_id = ? AND user_id = ?", banModel.StreamerID, banModel.UserID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user ban: "+err.Error())
+	}
+
+	if err := insertModerationLog(ctx, tx, &ModerationLogModel{
+		LivestreamID: livestreamModel.ID,
+		UserID:       userID,
+		Action:       action,
+		TargetUserID: targetModel.ID,
+		Reason:       req.Reason,
+		CreatedAt:    banModel.CreatedAt,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert moderation log: "+err.Error())
+	}
+
+	// 配信者の全ての配信から、ユーザの過去のライブコメントを非表示にする
+	moderated := make(map[int64][]int64)
+	if req.HidePastLivecomments {
+		var livecommentModels []*LivecommentModel
+		query := "SELECT l.* FROM livecomments l INNER JOIN livestreams s ON s.id = l.livestream_id WHERE s.user_id = ? AND l.user_id = ? AND l.hidden_at = 0"
+		if err := tx.SelectContext(ctx, &livecommentModels, query, livestreamModel.UserID, targetModel.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomments: "+err.Error())
+		}
+		for _, livecommentModel := range livecommentModels {
+			if err := hideLivecomment(ctx, tx, livecommentModel, userID, livecommentHiddenReasonBan, 0); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide livecomment: "+err.Error())
+			}
+			moderated[livecommentModel.LivestreamID] = append(moderated[livecommentModel.LivestreamID], livecommentModel.ID)
+		}
+	}
+
+	ban, err := fillUserBanResponse(ctx, tx, banModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user ban: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	for livestreamID, livecommentIDs := range moderated {
+		refreshRankingsAfterCommit(c, livestreamID)
+		publishModeratedLivecomments(livestreamID, 0, livecommentIDs)
+	}
+
+	return c.JSON(http.StatusCreated, ban)
+}
+
+// ユーザBAN解除API
+// DELETE /api/livestream/:livestream_id/ban/:username
+// 配信者のみが行える
+// BANとタイムアウトのどちらも解除する
+func unbanUserHandler(c echo.Context) error {
+	return unbanUser(c, false)
+}
+
+// ユーザタイムアウト解除API
+// DELETE /api/livestream/:livestream_id/timeout/:username
+// 無期限のBANは解除しない
+// 配信者のみが行える
+func untimeoutUserHandler(c echo.Context) error {
+	return unbanUser(c, true)
+}
+
+func unbanUser(c echo.Context, timeoutOnly bool) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+	username := c.Param("username")
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	var targetModel UserModel
+	if err := tx.GetContext(ctx, &targetModel, "SELECT * FROM users WHERE name = ?", username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+
+	ban, err := getActiveUserBan(ctx, tx, livestreamModel.UserID, targetModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user ban: "+err.Error())
+	}
+	if ban == nil || (timeoutOnly && ban.ExpiresAt == 0) {
+		return echo.NewHTTPError(http.StatusNotFound, "the user is not banned")
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_bans WHERE id = ?", ban.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete user ban: "+err.Error())
+	}
+	if err := insertModerationLog(ctx, tx, &ModerationLogModel{
+		LivestreamID: livestreamModel.ID,
+		UserID:       userID,
+		Action:       moderationActionUnbanUser,
+		TargetUserID: targetModel.ID,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert moderation log: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// BAN一覧取得API
+// GET /api/livestream/:livestream_id/ban
+// 配信者のチャンネルでBANまたはタイムアウト中のユーザを返す
+func getUserBansHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getModeratableLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	var banModels []*UserBanModel
+	if err := tx.SelectContext(ctx, &banModels, "SELECT * FROM user_bans WHERE streamer_id = ? AND (expires_at = 0 OR expires_at > ?) ORDER BY created_at DESC", livestreamModel.UserID, time.Now().Unix()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user bans: "+err.Error())
+	}
+
+	bans := make([]UserBan, len(banModels))
+	for i := range banModels {
+		ban, err := fillUserBanResponse(ctx, tx, *banModels[i])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user ban: "+err.Error())
+		}
+		bans[i] = ban
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, bans)
+}
+
+// getModeratableLivestream は、配信者本人かコラボレーターのみが操作できる配信を取得する
+// 返すエラーはecho.NewHTTPErrorなので、呼び出し側ではそのまま返せば良い
+func getModeratableLivestream(ctx context.Context, tx *sqlx.Tx, livestreamID int64, userID int64) (*LivestreamModel, error) {
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+
+	canModerate, err := canModerateLivestream(ctx, tx, &livestreamModel, userID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderation permission: "+err.Error())
+	}
+	if !canModerate {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "can't moderate other streamer's livestream")
+	}
+
+	return &livestreamModel, nil
+}
+
+func fillUserBanResponse(ctx context.Context, tx *sqlx.Tx, banModel UserBanModel) (UserBan, error) {
+	userModel := UserModel{}
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ?", banModel.UserID); err != nil {
+		return UserBan{}, err
+	}
+	user, err := fillUserResponse(ctx, tx, userModel)
+	if err != nil {
+		return UserBan{}, err
+	}
+
+	return UserBan{
+		ID:           banModel.ID,
+		User:         user,
+		LivestreamID: banModel.LivestreamID,
+		Reason:       banModel.Reason,
+		ExpiresAt:    banModel.ExpiresAt,
+		CreatedAt:    banModel.CreatedAt,
+	}, nil
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	collaboratorStatusPending  = "pending"
+	collaboratorStatusAccepted = "accepted"
+	collaboratorStatusDeclined = "declined"
+)
+
+type LivestreamCollaboratorModel struct {
+	ID           int64  `db:"id"`
+	LivestreamID int64  `db:"livestream_id"`
+	UserID       int64  `db:"user_id"`
+	Status       string `db:"status"`
+	// TipSharePercent は、コラボレーターに分配するチップの割合(%)。残りは配信者の取り分
+	TipSharePercent int64 `db:"tip_share_percent"`
+	CreatedAt       int64 `db:"created_at"`
+	UpdatedAt       int64 `db:"updated_at"`
+}
+
+type LivestreamCollaborator struct {
+	User            User  `json:"user"`
+	TipSharePercent int64 `json:"tip_share_percent"`
+}
+
+type CollaboratorInvitation struct {
+	Livestream Livestream `json:"livestream"`
+	CreatedAt  int64      `json:"created_at"`
+}
+
+type InviteCollaboratorRequest struct {
+	Username string `json:"username"`
+}
+
+type PutTipSplitRequest struct {
+	Splits []TipSplit `json:"splits"`
+}
+
+type TipSplit struct {
+	Username        string `json:"username"`
+	TipSharePercent int64  `json:"tip_share_percent"`
+}
+
+// コラボレーター招待API
+// POST /api/livestream/:livestream_id/collaborator
+func inviteCollaboratorHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var req *InviteCollaboratorRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	if err := inviteCollaborators(ctx, tx, livestreamModel, []string{req.Username}); err != nil {
+		return err
+	}
+
+	livestream, err := fillLivestreamResponse(ctx, tx, *livestreamModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, livestream)
+}
+
+// コラボレーター削除API
+// 配信者による取り消しと、コラボレーター自身による辞退のどちらにも用いる
+// DELETE /api/livestream/:livestream_id/collaborator/:username
+func deleteCollaboratorHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+	username := c.Param("username")
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+
+	var collaboratorUser UserModel
+	if err := tx.GetContext(ctx, &collaboratorUser, "SELECT * FROM users WHERE name = ?", username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+
+	if livestreamModel.UserID != userID && collaboratorUser.ID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "can't remove other users from the collaborators")
+	}
+
+	rs, err := tx.ExecContext(ctx, "DELETE FROM livestream_collaborators WHERE livestream_id = ? AND user_id = ?", livestreamModel.ID, collaboratorUser.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete collaborator: "+err.Error())
+	}
+	if deleted, err := rs.RowsAffected(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get deleted collaborators count: "+err.Error())
+	} else if deleted == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "the user is not a collaborator of the livestream")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// コラボレーター招待の承諾API
+// POST /api/livestream/:livestream_id/collaborator/accept
+func acceptCollaborationHandler(c echo.Context) error {
+	return respondCollaborationInvitation(c, collaboratorStatusAccepted)
+}
+
+// コラボレーター招待の拒否API
+// POST /api/livestream/:livestream_id/collaborator/decline
+func declineCollaborationHandler(c echo.Context) error {
+	return respondCollaborationInvitation(c, collaboratorStatusDeclined)
+}
+
+func respondCollaborationInvitation(c echo.Context, status string) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var collaboratorModel LivestreamCollaboratorModel
+	if err := tx.GetContext(ctx, &collaboratorModel, "SELECT * FROM livestream_collaborators WHERE livestream_id = ? AND user_id = ? FOR UPDATE", livestreamID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found collaboration invitation")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get collaboration invitation: "+err.Error())
+	}
+	if collaboratorModel.Status != collaboratorStatusPending {
+		return echo.NewHTTPError(http.StatusBadRequest, "the collaboration invitation has already been answered")
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE livestream_collaborators SET status = ?, updated_at = ? WHERE id = ?", status, time.Now().Unix(), collaboratorModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update collaboration invitation: "+err.Error())
+	}
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+	livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, livestream)
+}
+
+// 自分宛ての未回答のコラボレーター招待一覧API
+// GET /api/user/me/collaborator/invitation
+func getCollaborationInvitationsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var collaboratorModels []*LivestreamCollaboratorModel
+	if err := tx.SelectContext(ctx, &collaboratorModels, "SELECT * FROM livestream_collaborators WHERE user_id = ? AND status = ? ORDER BY created_at DESC, id DESC", userID, collaboratorStatusPending); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get collaboration invitations: "+err.Error())
+	}
+
+	invitations := make([]CollaboratorInvitation, len(collaboratorModels))
+	for i := range collaboratorModels {
+		var livestreamModel LivestreamModel
+		if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", collaboratorModels[i].LivestreamID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+		livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+		}
+		invitations[i] = CollaboratorInvitation{
+			Livestream: livestream,
+			CreatedAt:  collaboratorModels[i].CreatedAt,
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, invitations)
+}
+
+// コラボレーターへのチップの分配率設定API
+// 指定されなかったコラボレーターの分配率は0になる
+// 設定した分配率は、以降に受け取るチップから適用する
+// PUT /api/livestream/:livestream_id/tip_split
+func putTipSplitHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var req *PutTipSplitRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+
+	var totalPercent int64
+	for _, split := range req.Splits {
+		if split.TipSharePercent < 0 || split.TipSharePercent > 100 {
+			return echo.NewHTTPError(http.StatusBadRequest, "tip_share_percent must be between 0 and 100")
+		}
+		totalPercent += split.TipSharePercent
+	}
+	if totalPercent > 100 {
+		return echo.NewHTTPError(http.StatusBadRequest, "total of tip_share_percent must not exceed 100")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	if _, err := tx.ExecContext(ctx, "UPDATE livestream_collaborators SET tip_share_percent = 0, updated_at = ? WHERE livestream_id = ?", now, livestreamModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reset tip split: "+err.Error())
+	}
+	for _, split := range req.Splits {
+		var collaboratorModel LivestreamCollaboratorModel
+		if err := tx.GetContext(ctx, &collaboratorModel, "SELECT lc.* FROM livestream_collaborators lc INNER JOIN users u ON u.id = lc.user_id WHERE lc.livestream_id = ? AND u.name = ? FOR UPDATE", livestreamModel.ID, split.Username); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusBadRequest, "not a collaborator of the livestream: "+split.Username)
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get collaborator: "+err.Error())
+		}
+		// 招待を承諾していないユーザには分配しない
+		if collaboratorModel.Status != collaboratorStatusAccepted {
+			return echo.NewHTTPError(http.StatusBadRequest, "the collaborator has not accepted the invitation: "+split.Username)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE livestream_collaborators SET tip_share_percent = ?, updated_at = ? WHERE id = ?", split.TipSharePercent, now, collaboratorModel.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update tip split: "+err.Error())
+		}
+	}
+
+	livestream, err := fillLivestreamResponse(ctx, tx, *livestreamModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, livestream)
+}
+
+// getOwnedLivestream は、配信者自身のライブ配信を取得する
+// 返すエラーはecho.NewHTTPErrorなので、呼び出し側ではそのまま返せば良い
+func getOwnedLivestream(ctx context.Context, tx *sqlx.Tx, livestreamID int64, userID int64) (*LivestreamModel, error) {
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+
+	if livestreamModel.UserID != userID {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "can't manage other streamer's livestream")
+	}
+
+	return &livestreamModel, nil
+}
+
+// inviteCollaborators は、指定したユーザをコラボレーターとして招待する
+// 返すエラーはecho.NewHTTPErrorなので、呼び出し側ではそのまま返せば良い
+func inviteCollaborators(ctx context.Context, tx *sqlx.Tx, livestreamModel *LivestreamModel, usernames []string) error {
+	now := time.Now().Unix()
+	for _, username := range usernames {
+		var userModel UserModel
+		if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", username); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusBadRequest, "not found collaborator that has the given username: "+username)
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+		if userModel.ID == livestreamModel.UserID {
+			return echo.NewHTTPError(http.StatusBadRequest, "can't invite yourself as a collaborator")
+		}
+
+		var collaboratorModel LivestreamCollaboratorModel
+		err := tx.GetContext(ctx, &collaboratorModel, "SELECT * FROM livestream_collaborators WHERE livestream_id = ? AND user_id = ? FOR UPDATE", livestreamModel.ID, userModel.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get collaborator: "+err.Error())
+		}
+		if err == nil {
+			if collaboratorModel.Status != collaboratorStatusDeclined {
+				return echo.NewHTTPError(http.StatusBadRequest, "the user has already been invited: "+username)
+			}
+			// 招待を拒否したユーザは、改めて招待できる
+			if _, err := tx.ExecContext(ctx, "UPDATE livestream_collaborators SET status = ?, tip_share_percent = 0, created_at = ?, updated_at = ? WHERE id = ?", collaboratorStatusPending, now, now, collaboratorModel.ID); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to update collaborator: "+err.Error())
+			}
+			continue
+		}
+
+		if _, err := tx.NamedExecContext(ctx, "INSERT INTO livestream_collaborators (livestream_id, user_id, status, tip_share_percent, created_at, updated_at) VALUES (:livestream_id, :user_id, :status, :tip_share_percent, :created_at, :updated_at)", &LivestreamCollaboratorModel{
+			LivestreamID: livestreamModel.ID,
+			UserID:       userModel.ID,
+			Status:       collaboratorStatusPending,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert collaborator: "+err.Error())
+		}
+	}
+
+	return nil
+}
+
+// canModerateLivestream は、配信者本人か、招待を承諾したコラボレーターであるかを返す
+func canModerateLivestream(ctx context.Context, tx *sqlx.Tx, livestreamModel *LivestreamModel, userID int64) (bool, error) {
+	if livestreamModel.UserID == userID {
+		return true, nil
+	}
+
+	var count int64
+	if err := tx.GetContext(ctx, &count, "SELECT COUNT(*) FROM livestream_collaborators WHERE livestream_id = ? AND user_id = ? AND status = ?", livestreamModel.ID, userID, collaboratorStatusAccepted); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	recentlyWatchedStreamersDefaultLimit = 10
+	recentlyWatchedStreamersMaxLimit     = 50
+)
+
+// WatchHistory は、視聴者の視聴記録
+type WatchHistory struct {
+	ID         int64      `json:"id"`
+	Livestream Livestream `json:"livestream"`
+	EnteredAt  int64      `json:"entered_at"`
+	// 0の場合は視聴中
+	ExitedAt int64 `json:"exited_at"`
+	// 視聴時間(秒)
+	Duration int64 `json:"duration"`
+}
+
+type RecentlyWatchedStreamer struct {
+	Streamer User `json:"streamer"`
+	// 最後に視聴したライブ配信
+	Livestream    Livestream `json:"livestream"`
+	LastWatchedAt int64      `json:"last_watched_at"`
+}
+
+// watchDuration は、視聴時間(秒)を返す
+// 退出していない場合は現在時刻まで視聴しているものとし、配信終了時刻より後は数えない
+func watchDuration(sessionModel *LivestreamViewerSessionModel, livestreamModel *LivestreamModel, now int64) int64 {
+	end := sessionModel.ExitedAt
+	if end == 0 {
+		end = now
+	}
+	if end > livestreamModel.EndAt {
+		end = livestreamModel.EndAt
+	}
+	if end < sessionModel.EnteredAt {
+		return 0
+	}
+	return end - sessionModel.EnteredAt
+}
+
+func fillWatchHistoryResponse(ctx context.Context, tx *sqlx.Tx, sessionModel *LivestreamViewerSessionModel, now int64) (WatchHistory, error) {
+	livestreamModel := LivestreamModel{}
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", sessionModel.LivestreamID); err != nil {
+		return WatchHistory{}, err
+	}
+	livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+	if err != nil {
+		return WatchHistory{}, err
+	}
+
+	return WatchHistory{
+		ID:         sessionModel.ID,
+		Livestream: livestream,
+		EnteredAt:  sessionModel.EnteredAt,
+		ExitedAt:   sessionModel.ExitedAt,
+		Duration:   watchDuration(sessionModel, &livestreamModel, now),
+	}, nil
+}
+
+// 視聴履歴取得API
+// GET /api/user/me/history
+func getMyWatchHistoryHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	page, err := parsePageQuery(c)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT * FROM livestream_viewer_sessions WHERE user_id = ?"
+	args := []interface{}{userID}
+	if cond, condArgs := page.IDCondition("id"); cond != "" {
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	query += " ORDER BY id " + page.Order() + page.LimitClause()
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var sessionModels []*LivestreamViewerSessionModel
+	if err := tx.SelectContext(ctx, &sessionModels, query, args...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream_viewer_sessions: "+err.Error())
+	}
+	if page.After != nil {
+		// 新しい順に揃える
+		for i, j := 0, len(sessionModels)-1; i < j; i, j = i+1, j-1 {
+			sessionModels[i], sessionModels[j] = sessionModels[j], sessionModels[i]
+		}
+	}
+
+	now := time.Now().Unix()
+	histories := make([]WatchHistory, len(sessionModels))
+	for i := range sessionModels {
+		history, err := fillWatchHistoryResponse(ctx, tx, sessionModels[i], now)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill watch history: "+err.Error())
+		}
+		histories[i] = history
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	if len(histories) > 0 {
+		setNextPageLink(c, page, len(histories), PageCursor{ID: histories[0].ID}, PageCursor{ID: histories[len(histories)-1].ID})
+	}
+
+	return c.JSON(http.StatusOK, histories)
+}
+
+// 最近視聴した配信者一覧取得API
+// GET /api/user/me/history/streamers?limit=
+func getRecentlyWatchedStreamersHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	limit := recentlyWatchedStreamersDefaultLimit
+	if v := c.QueryParam("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > recentlyWatchedStreamersMaxLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit query parameter must be between 1 and %d", recentlyWatchedStreamersMaxLimit))
+		}
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	// 配信者ごとに、最後に視聴を開始した記録を取得する
+	var sessionIDs []int64
+	query := "SELECT MAX(v.id) AS id FROM livestream_viewer_sessions v INNER JOIN livestreams l ON l.id = v.livestream_id" +
+		" WHERE v.user_id = ? GROUP BY l.user_id ORDER BY id DESC LIMIT ?"
+	if err := tx.SelectContext(ctx, &sessionIDs, query, userID, limit); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get recently watched streamers: "+err.Error())
+	}
+
+	streamers := make([]RecentlyWatchedStreamer, len(sessionIDs))
+	for i, sessionID := range sessionIDs {
+		sessionModel := LivestreamViewerSessionModel{}
+		if err := tx.GetContext(ctx, &sessionModel, "SELECT * FROM livestream_viewer_sessions WHERE id = ?", sessionID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream_viewer_session: "+err.Error())
+		}
+		livestreamModel := LivestreamModel{}
+		if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", sessionModel.LivestreamID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+		livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+		}
+
+		streamers[i] = RecentlyWatchedStreamer{
+			Streamer:      livestream.Owner,
+			Livestream:    livestream,
+			LastWatchedAt: sessionModel.EnteredAt,
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, streamers)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// アップロードできるアイコンの最大サイズ
+	iconMaxBytes = 4 << 20
+	// アップロードできるアイコンの最大の幅・高さ
+	// デコード後の画像はメモリに展開されるので、アイコンとして十分な大きさに抑える
+	iconMaxDimension = 1024
+
+	iconThumbnailJPEGQuality = 85
+)
+
+// iconThumbnailSizes は、?size= で取得できる正方形のサムネイルの一辺の長さ
+var iconThumbnailSizes = []int{64, 128, 256}
+
+var fallbackImage = "../img/NoImage.jpg"
+
+var errInvalidIcon = errors.New("invalid icon")
+
+// IconModel は、アップロードされたアイコン
+// icon_hashがアップロードされた画像のSHA-256なので、imageは変換せずにそのまま保存する
+type IconModel struct {
+	ID          int64  `db:"id"`
+	UserID      int64  `db:"user_id"`
+	Image       []byte `db:"image"`
+	IconHash    string `db:"icon_hash"`
+	ContentType string `db:"content_type"`
+}
+
+// IconThumbnailModel は、アイコンを縮小したサムネイル
+type IconThumbnailModel struct {
+	UserID      int64  `db:"user_id"`
+	Size        int    `db:"size"`
+	Image       []byte `db:"image"`
+	ContentType string `db:"content_type"`
+}
+
+// fallbackIcon は、アイコン未設定のユーザに返すfallbackImageとそのサムネイル (初回に読み込む)
+var (
+	fallbackIconOnce sync.Once
+	fallbackIcon     *IconModel
+	fallbackIconErr  error
+	fallbackThumbs   []*IconThumbnailModel
+)
+
+func loadFallbackIcon() (*IconModel, []*IconThumbnailModel, error) {
+	fallbackIconOnce.Do(func() {
+		data, err := os.ReadFile(fallbackImage)
+		if err != nil {
+			fallbackIconErr = err
+			return
+		}
+		icon, thumbs, err := normalizeIcon(data)
+		if err != nil {
+			fallbackIconErr = fmt.Errorf("failed to normalize %s: %w", fallbackImage, err)
+			return
+		}
+		fallbackIcon, fallbackThumbs = icon, thumbs
+	})
+	return fallbackIcon, fallbackThumbs, fallbackIconErr
+}
+
+// getIconHash は、ユーザのアイコンのSHA-256を返す
+// アイコンが未設定の場合は、fallbackImageのものを返す
+func getIconHash(ctx context.Context, tx *sqlx.Tx, userID int64) (string, error) {
+	var iconHash string
+	if err := tx.GetContext(ctx, &iconHash, "SELECT icon_hash FROM icons WHERE user_id = ?", userID); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+		icon, _, err := loadFallbackIcon()
+		if err != nil {
+			return "", err
+		}
+		return icon.IconHash, nil
+	}
+	return iconHash, nil
+}
+
+// matchesETag は、If-None-Matchヘッダがetagに一致するかを返す
+// If-None-Matchは弱い比較を行う (RFC 9110 13.1.2)
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidIconThumbnailSize(size int) bool {
+	for _, s := range iconThumbnailSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeIcon は、アップロードされた画像を検証し、形式の判定とサムネイルの生成を行う
+// 画像として解釈できない場合や、大きすぎる場合はerrInvalidIconを返す
+func normalizeIcon(data []byte) (*IconModel, []*IconThumbnailModel, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: image is empty", errInvalidIcon)
+	}
+	if len(data) > iconMaxBytes {
+		return nil, nil, fmt.Errorf("%w: image must be smaller than %d bytes", errInvalidIcon, iconMaxBytes)
+	}
+
+	// 展開後のサイズが大きすぎる画像は、デコードする前に弾く
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: unsupported image format", errInvalidIcon)
+	}
+	if config.Width < 1 || config.Height < 1 || config.Width > iconMaxDimension || config.Height > iconMaxDimension {
+		return nil, nil, fmt.Errorf("%w: image must be at most %dx%d", errInvalidIcon, iconMaxDimension, iconMaxDimension)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: failed to decode image", errInvalidIcon)
+	}
+
+	icon := &IconModel{
+		Image:       data,
+		IconHash:    fmt.Sprintf("%x", sha256.Sum256(data)),
+		ContentType: "image/" + format,
+	}
+
+	// 透過のないJPEGはJPEGのまま、それ以外はPNGで保存する
+	thumbs := make([]*IconThumbnailModel, len(iconThumbnailSizes))
+	for i, size := range iconThumbnailSizes {
+		var buf bytes.Buffer
+		thumb := &IconThumbnailModel{Size: size}
+		resized := resizeIconSquare(img, size)
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: iconThumbnailJPEGQuality})
+			thumb.ContentType = "image/jpeg"
+		} else {
+			err = png.Encode(&buf, resized)
+			thumb.ContentType = "image/png"
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		thumb.Image = buf.Bytes()
+		thumbs[i] = thumb
+	}
+
+	return icon, thumbs, nil
+}
+
+// resizeIconSquare は、中央を正方形に切り抜いて、一辺sizeに縮小(または拡大)する
+// 各画素は、対応する元画像の範囲の平均を取る
+// 元画像全体をRGBAに変換したコピーは作らず、デコードした画像から直接読み出す
+func resizeIconSquare(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for dy := 0; dy < size; dy++ {
+		sy0 := y0 + dy*side/size
+		sy1 := max(y0+(dy+1)*side/size, sy0+1)
+		for dx := 0; dx < size; dx++ {
+			sx0 := x0 + dx*side/size
+			sx1 := max(x0+(dx+1)*side/size, sx0+1)
+
+			// RGBA()はアルファ乗算済みの16bit値を返す
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					bl += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+			j := dst.PixOffset(dx, dy)
+			dst.Pix[j] = uint8(r / n >> 8)
+			dst.Pix[j+1] = uint8(g / n >> 8)
+			dst.Pix[j+2] = uint8(bl / n >> 8)
+			dst.Pix[j+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	idempotencyKeyHeader         = "Idempotency-Key"
+	idempotentReplayedHeader     = "Idempotent-Replayed"
+	idempotencyKeyMaxLength      = 255
+	idempotencyKeyRetentionLimit = 24 * time.Hour
+	// 保持期間を過ぎたキーを削除する間隔
+	idempotencyKeyPurgeInterval = 10 * time.Minute
+
+	// ER_LOCK_DEADLOCK
+	mysqlErrLockDeadlock = 1213
+)
+
+// IdempotencyKeyModel は、Idempotency-Keyと、それに対して返したレスポンス
+type IdempotencyKeyModel struct {
+	ID             int64  `db:"id"`
+	UserID         int64  `db:"user_id"`
+	IdempotencyKey string `db:"idempotency_key"`
+	// METHOD PATH
+	Endpoint string `db:"endpoint"`
+	// リクエストボディのSHA-256
+	RequestHash  string `db:"request_hash"`
+	StatusCode   int    `db:"status_code"`
+	ResponseBody string `db:"response_body"`
+	CreatedAt    int64  `db:"created_at"`
+}
+
+// beginIdempotentRequest は、Idempotency-Keyヘッダがあればキーを予約する
+// 保持期間内に同じキーで成功したリクエストがあれば、そのときのレスポンスを返すので、呼び出し側はそれをそのまま返すこと
+// キーの予約はトランザクション内で行うため、同じキーで同時に来たリクエストは先のリクエストが終わるまで待たされる
+// Idempotency-Keyヘッダが無い場合は、どちらもnilを返す
+func beginIdempotentRequest(ctx context.Context, tx *sqlx.Tx, c echo.Context, userID int64, body []byte) (reserved *IdempotencyKeyModel, replay *IdempotencyKeyModel, err error) {
+	key := c.Request().Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		return nil, nil, nil
+	}
+	if len(key) > idempotencyKeyMaxLength {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Idempotency-Key is too long")
+	}
+
+	hash := sha256.Sum256(body)
+	now := time.Now()
+	keyModel := &IdempotencyKeyModel{
+		UserID:         userID,
+		IdempotencyKey: key,
+		Endpoint:       c.Request().Method + " " + c.Request().URL.Path,
+		RequestHash:    hex.EncodeToString(hash[:]),
+		CreatedAt:      now.Unix(),
+	}
+
+	// 処理中の同じキーがあれば、そのトランザクションが終わるまでブロックされる
+	// NOTE: INSERT IGNOREは既存の行を共有ロックするため、後からFOR UPDATEすると同じキーのリクエスト同士でデッドロックする
+	// ON DUPLICATE KEY UPDATEで、挿入した場合も既存の行の場合も最初から排他ロックを取る
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO idempotency_keys (user_id, idempotency_key, endpoint, request_hash, status_code, response_body, created_at) VALUES (:user_id, :idempotency_key, :endpoint, :request_hash, 0, '', :created_at) ON DUPLICATE KEY UPDATE id = id", keyModel)
+	if err != nil {
+		return nil, nil, idempotencyKeyError("failed to insert idempotency key", err)
+	}
+	// 既存の行は変更しないので、挿入した場合のみ1になる
+	if n, err := rs.RowsAffected(); err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get affected rows: "+err.Error())
+	} else if n == 1 {
+		return keyModel, nil, nil
+	}
+
+	var existing IdempotencyKeyModel
+	if err := tx.GetContext(ctx, &existing, "SELECT * FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ? FOR UPDATE", userID, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, echo.NewHTTPError(http.StatusConflict, "a request with the same Idempotency-Key is in progress")
+		}
+		return nil, nil, idempotencyKeyError("failed to get idempotency key", err)
+	}
+
+	// 保持期間を過ぎたキーは、新しいリクエストとして扱う
+	if existing.CreatedAt <= now.Add(-idempotencyKeyRetentionLimit).Unix() {
+		if _, err := tx.NamedExecContext(ctx, "UPDATE idempotency_keys SET endpoint = :endpoint, request_hash = :request_hash, status_code = 0, response_body = '', created_at = :created_at WHERE user_id = :user_id AND idempotency_key = :idempotency_key", keyModel); err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to update idempotency key: "+err.Error())
+		}
+		return keyModel, nil, nil
+	}
+
+	if existing.Endpoint != keyModel.Endpoint || existing.RequestHash != keyModel.RequestHash {
+		return nil, nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "Idempotency-Key is already used for a different request")
+	}
+	return nil, &existing, nil
+}
+
+// idempotencyKeyError は、キーの予約に失敗したときのエラーを返す
+// 同じキーのリクエストとのデッドロックで中断された場合は、リトライできるよう409を返す
+func idempotencyKeyError(message string, err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrLockDeadlock {
+		return echo.NewHTTPError(http.StatusConflict, "a request with the same Idempotency-Key is in progress")
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, message+": "+err.Error())
+}
+
+// purgeIdempotencyKeys は、保持期間を過ぎたキーを削除する
+func purgeIdempotencyKeys(ctx context.Context, db *sqlx.DB, now time.Time) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at <= ?", now.Add(-idempotencyKeyRetentionLimit).Unix())
+	return err
+}
+
+// runIdempotencyKeyPurger は、定期的に保持期間を過ぎたキーを削除する
+func runIdempotencyKeyPurger(ctx context.Context, db *sqlx.DB, logger echo.Logger) {
+	ticker := time.NewTicker(idempotencyKeyPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := purgeIdempotencyKeys(ctx, db, now); err != nil {
+				logger.Warnf("failed to purge idempotency keys: %s", err.Error())
+			}
+		}
+	}
+}
+
+// completeIdempotentRequest は、予約したキーに対してレスポンスを保存する
+// beginIdempotentRequestと同じトランザクションで、コミット前に呼び出すこと
+func completeIdempotentRequest(ctx context.Context, tx *sqlx.Tx, reserved *IdempotencyKeyModel, statusCode int, response interface{}) error {
+	if reserved == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	reserved.StatusCode = statusCode
+	reserved.ResponseBody = string(body)
+	_, err = tx.NamedExecContext(ctx, "UPDATE idempotency_keys SET status_code = :status_code, response_body = :response_body WHERE user_id = :user_id AND idempotency_key = :idempotency_key", reserved)
+	return err
+}
+
+// replayIdempotentResponse は、保存されたレスポンスをそのまま返す
+func replayIdempotentResponse(c echo.Context, replay *IdempotencyKeyModel) error {
+	c.Response().Header().Set(idempotentReplayedHeader, "true")
+	return c.JSONBlob(replay.StatusCode, []byte(replay.ResponseBody))
+}
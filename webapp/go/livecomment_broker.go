@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// 購読者ごとのイベントバッファ。溢れた購読者は切断し、Last-Event-IDで再接続させる
+	livecommentSubscriberBufferSize = 64
+	// プロキシにアイドル接続として切断されないよう、定期的にコメント行を送る
+	livecommentStreamKeepaliveInterval = 15 * time.Second
+	// 再接続時には、Last-Event-IDのライブコメントより少し前に投稿されたものから送り直す
+	// IDの小さいライブコメントが後からコミットされた場合に取りこぼさないため (クライアントはIDで重複を除くこと)
+	livecommentStreamReplayWindow = 5 * time.Second
+
+	livecommentEventTypeComment  = "livecomment"
+	livecommentEventTypeModerate = "moderate"
+	livecommentEventTypeRestore  = "restore"
+)
+
+// LivecommentEvent は、ライブコメントストリームに流すイベント
+type LivecommentEvent struct {
+	// ID は、SSEのidフィールドに用いるライブコメントID。0の場合はidを送出しない
+	ID   int64
+	Type string
+	Data interface{}
+}
+
+type ModeratedLivecommentsEvent struct {
+	NGWordID       int64   `json:"ng_word_id,omitempty"`
+	LivecommentIDs []int64 `json:"livecomment_ids"`
+}
+
+type livecommentSubscriber struct {
+	events chan *LivecommentEvent
+}
+
+// livecommentBroker は、ライブ配信ごとの購読者へライブコメントのイベントを配信するプロセス内ブローカー
+type livecommentBroker struct {
+	mu          sync.Mutex
+	subscribers map[int64]map[*livecommentSubscriber]struct{}
+}
+
+var livecommentEventBroker = newLivecommentBroker()
+
+func newLivecommentBroker() *livecommentBroker {
+	return &livecommentBroker{
+		subscribers: make(map[int64]map[*livecommentSubscriber]struct{}),
+	}
+}
+
+func (b *livecommentBroker) Subscribe(livestreamID int64) *livecommentSubscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &livecommentSubscriber{
+		events: make(chan *LivecommentEvent, livecommentSubscriberBufferSize),
+	}
+	if _, ok := b.subscribers[livestreamID]; !ok {
+		b.subscribers[livestreamID] = make(map[*livecommentSubscriber]struct{})
+	}
+	b.subscribers[livestreamID][sub] = struct{}{}
+
+	return sub
+}
+
+func (b *livecommentBroker) Unsubscribe(livestreamID int64, sub *livecommentSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.removeLocked(livestreamID, sub)
+}
+
+func (b *livecommentBroker) Publish(livestreamID int64, event *LivecommentEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers[livestreamID] {
+		select {
+		case sub.events <- event:
+		default:
+			// 受信が追いつかない購読者は切断する
+			b.removeLocked(livestreamID, sub)
+		}
+	}
+}
+
+func (b *livecommentBroker) removeLocked(livestreamID int64, sub *livecommentSubscriber) {
+	subs, ok := b.subscribers[livestreamID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	close(sub.events)
+	if len(subs) == 0 {
+		delete(b.subscribers, livestreamID)
+	}
+}
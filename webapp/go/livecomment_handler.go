@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -26,7 +28,11 @@ type LivecommentModel struct {
 	LivestreamID int64  `db:"livestream_id"`
 	Comment      string `db:"comment"`
 	Tip          int64  `db:"tip"`
-	CreatedAt    int64  `db:"created_at"`
+	// HiddenAt は、moderationにより非表示にされた日時。0の場合は表示中
+	HiddenAt         int64  `db:"hidden_at"`
+	HiddenReason     string `db:"hidden_reason"`
+	HiddenByNGWordID int64  `db:"hidden_by_ng_word_id"`
+	CreatedAt        int64  `db:"created_at"`
 }
 
 type Livecomment struct {
@@ -42,19 +48,25 @@ type LivecommentReport struct {
 	ID          int64       `json:"id"`
 	Reporter    User        `json:"reporter"`
 	Livecomment Livecomment `json:"livecomment"`
+	Status      string      `json:"status"`
+	ResolvedAt  int64       `json:"resolved_at,omitempty"`
 	CreatedAt   int64       `json:"created_at"`
 }
 
 type LivecommentReportModel struct {
-	ID            int64 `db:"id"`
-	UserID        int64 `db:"user_id"`
-	LivestreamID  int64 `db:"livestream_id"`
-	LivecommentID int64 `db:"livecomment_id"`
-	CreatedAt     int64 `db:"created_at"`
+	ID            int64  `db:"id"`
+	UserID        int64  `db:"user_id"`
+	LivestreamID  int64  `db:"livestream_id"`
+	LivecommentID int64  `db:"livecomment_id"`
+	Status        string `db:"status"`
+	ResolvedAt    int64  `db:"resolved_at"`
+	CreatedAt     int64  `db:"created_at"`
 }
 
 type ModerateRequest struct {
 	NGWord string `json:"ng_word"`
+	// MatchType は substring, glob, regex のいずれか。省略時はsubstring
+	MatchType string `json:"match_type"`
 }
 
 type NGWord struct {
@@ -62,7 +74,10 @@ type NGWord struct {
 	UserID       int64  `json:"user_id" db:"user_id"`
 	LivestreamID int64  `json:"livestream_id" db:"livestream_id"`
 	Word         string `json:"word" db:"word"`
-	CreatedAt    int64  `json:"created_at" db:"created_at"`
+	MatchType    string `json:"match_type" db:"match_type"`
+	// Scope は、配信単位(livestream)かアカウント単位(account)か
+	Scope     string `json:"scope" db:"-"`
+	CreatedAt int64  `json:"created_at" db:"created_at"`
 }
 
 func getLivecommentsHandler(c echo.Context) error {
@@ -84,23 +99,35 @@ func getLivecommentsHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	query := "SELECT * FROM livecomments WHERE livestream_id = ? ORDER BY created_at DESC"
-	if c.QueryParam("limit") != "" {
-		limit, err := strconv.Atoi(c.QueryParam("limit"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
-		}
-		query += fmt.Sprintf(" LIMIT %d", limit)
+	page, err := parsePageQuery(c)
+	if err != nil {
+		return err
+	}
+
+	// 非表示にされたライブコメントは返さない
+	query := "SELECT * FROM livecomments WHERE livestream_id = ? AND hidden_at = 0"
+	args := []interface{}{livestreamID}
+	if cond, condArgs := page.TimelineCondition(); cond != "" {
+		query += " AND " + cond
+		args = append(args, condArgs...)
 	}
+	query += fmt.Sprintf(" ORDER BY created_at %[1]s, id %[1]s", page.Order())
+	query += page.LimitClause()
 
 	livecommentModels := []LivecommentModel{}
-	err = tx.SelectContext(ctx, &livecommentModels, query, livestreamID)
+	err = tx.SelectContext(ctx, &livecommentModels, query, args...)
 	if errors.Is(err, sql.ErrNoRows) {
 		return c.JSON(http.StatusOK, []*Livecomment{})
 	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomments: "+err.Error())
 	}
+	if page.After != nil {
+		// 新しい順に揃える
+		for i, j := 0, len(livecommentModels)-1; i < j; i, j = i+1, j-1 {
+			livecommentModels[i], livecommentModels[j] = livecommentModels[j], livecommentModels[i]
+		}
+	}
 
 	livecomments := make([]Livecomment, len(livecommentModels))
 	for i := range livecommentModels {
@@ -116,9 +143,165 @@ func getLivecommentsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	if n := len(livecommentModels); n > 0 {
+		newest, oldest := livecommentModels[0], livecommentModels[n-1]
+		setNextPageLink(c, page, n,
+			PageCursor{ID: newest.ID, CreatedAt: newest.CreatedAt},
+			PageCursor{ID: oldest.ID, CreatedAt: oldest.CreatedAt})
+	}
+
 	return c.JSON(http.StatusOK, livecomments)
 }
 
+// ライブコメントのストリーミング取得API (Server-Sent Events)
+// GET /api/livestream/:livestream_id/livecomment/stream
+func streamLivecommentsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	// EventSourceは初回接続時にヘッダを指定できないため、クエリパラメータでも受け付ける
+	lastEventIDParam := c.Request().Header.Get("Last-Event-ID")
+	if lastEventIDParam == "" {
+		lastEventIDParam = c.QueryParam("last_event_id")
+	}
+	var lastEventID int64
+	if lastEventIDParam != "" {
+		lastEventID, err = strconv.ParseInt(lastEventIDParam, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Last-Event-ID must be integer")
+		}
+	}
+
+	// 取りこぼしを防ぐため、過去分を読む前に購読を開始しておく
+	sub := livecommentEventBroker.Subscribe(int64(livestreamID))
+	defer livecommentEventBroker.Unsubscribe(int64(livestreamID), sub)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		} else {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+	}
+
+	var backlog []Livecomment
+	if lastEventID > 0 {
+		// Last-Event-IDのライブコメントが見つからない場合は、IDより後のものだけを送る
+		replayFrom := int64(math.MaxInt64)
+		var lastCreatedAt int64
+		if err := tx.GetContext(ctx, &lastCreatedAt, "SELECT created_at FROM livecomments WHERE id = ?", lastEventID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+		} else if err == nil {
+			replayFrom = lastCreatedAt - int64(livecommentStreamReplayWindow/time.Second)
+		}
+
+		var livecommentModels []LivecommentModel
+		if err := tx.SelectContext(ctx, &livecommentModels, "SELECT * FROM livecomments WHERE livestream_id = ? AND (id > ? OR created_at >= ?) AND hidden_at = 0 ORDER BY id ASC", livestreamID, lastEventID, replayFrom); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomments: "+err.Error())
+		}
+
+		backlog = make([]Livecomment, len(livecommentModels))
+		for i := range livecommentModels {
+			livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModels[i])
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
+			}
+			backlog[i] = livecomment
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	// nginxでレスポンスがバッファリングされないようにする
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	// ここから先はレスポンスを書き始めているので、エラーはログに出して接続を閉じる
+	// ライブコメントのIDはコミット順とは限らないため、IDの大小ではなく過去分として送信したかどうかで重複を判定する
+	sentIDs := make(map[int64]struct{}, len(backlog))
+	for _, livecomment := range backlog {
+		if err := writeLivecommentEvent(res, &LivecommentEvent{
+			ID:   livecomment.ID,
+			Type: livecommentEventTypeComment,
+			Data: livecomment,
+		}); err != nil {
+			c.Logger().Warnf("failed to write livecomment event: %+v", err)
+			return nil
+		}
+		sentIDs[livecomment.ID] = struct{}{}
+	}
+
+	keepalive := time.NewTicker(livecommentStreamKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(res, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case event, ok := <-sub.events:
+			if !ok {
+				// ブローカーから切断された
+				return nil
+			}
+			// 過去分として送信済みのものは送らない
+			if _, sent := sentIDs[event.ID]; sent {
+				delete(sentIDs, event.ID)
+				continue
+			}
+			if err := writeLivecommentEvent(res, event); err != nil {
+				c.Logger().Warnf("failed to write livecomment event: %+v", err)
+				return nil
+			}
+		}
+	}
+}
+
+func writeLivecommentEvent(res *echo.Response, event *LivecommentEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+
+	if event.ID > 0 {
+		if _, err := fmt.Fprintf(res, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+	res.Flush()
+
+	return nil
+}
+
 func getNgwords(c echo.Context) error {
 	ctx := c.Request().Context()
 
@@ -142,13 +325,30 @@ func getNgwords(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
+	// 配信者とコラボレーターには、アカウント単位のものも含め、配信に適用されるNGワードを返す
+	var livestreamModel LivestreamModel
+	canModerate := false
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	} else if err == nil {
+		canModerate, err = canModerateLivestream(ctx, tx, &livestreamModel, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderation permission: "+err.Error())
+		}
+	}
+
 	var ngWords []*NGWord
-	if err := tx.SelectContext(ctx, &ngWords, "SELECT * FROM ng_words WHERE user_id = ? AND livestream_id = ? ORDER BY created_at DESC", userID, livestreamID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return c.JSON(http.StatusOK, []*NGWord{})
-		} else {
+	if canModerate {
+		ngWords, err = getEffectiveNGWords(ctx, tx, &livestreamModel)
+		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
 		}
+	} else {
+		ngWords = []*NGWord{}
+		if err := tx.SelectContext(ctx, &ngWords, "SELECT * FROM ng_words WHERE user_id = ? AND livestream_id = ? ORDER BY created_at DESC", userID, livestreamID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+		}
+		fillNGWordScopes(ngWords)
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -176,8 +376,13 @@ func postLivecommentHandler(c echo.Context) error {
 	// existence already checked
 	userID := sess.Values[defaultUserIDKey].(int64)
 
+	// Idempotency-Keyの照合のため、リクエストボディを保持しておく
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to read the request body")
+	}
 	var req *PostLivecommentRequest
-	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
@@ -187,6 +392,18 @@ func postLivecommentHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
+	// 同じIdempotency-Keyでのリトライであれば、最初のレスポンスをそのまま返す
+	idempotencyKey, replay, err := beginIdempotentRequest(ctx, tx, c, userID, body)
+	if err != nil {
+		return err
+	}
+	if replay != nil {
+		return replayIdempotentResponse(c, replay)
+	}
+	if err := checkRateLimit(c, livecommentRateLimiter, userID); err != nil {
+		return err
+	}
+
 	var livestreamModel LivestreamModel
 	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -196,31 +413,31 @@ func postLivecommentHandler(c echo.Context) error {
 		}
 	}
 
-	// スパム判定
-	var ngwords []*NGWord
-	if err := tx.SelectContext(ctx, &ngwords, "SELECT id, user_id, livestream_id, word FROM ng_words WHERE user_id = ? AND livestream_id = ?", livestreamModel.UserID, livestreamModel.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+	if err := verifyUserNotBanned(ctx, tx, c, livestreamModel.UserID, userID); err != nil {
+		return err
 	}
 
-	var hitSpam int
-	for _, ngword := range ngwords {
-		query := `
-		SELECT COUNT(*)
-		FROM
-		(SELECT ? AS text) AS texts
-		INNER JOIN
-		(SELECT CONCAT('%', ?, '%')	AS pattern) AS patterns
-		ON texts.text LIKE patterns.pattern;
-		`
-		if err := tx.GetContext(ctx, &hitSpam, query, req.Comment, ngword.Word); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get hitspam: "+err.Error())
+	// スローモード (チップ付きのライブコメントは対象外)
+	if req.Tip == 0 {
+		retryAfter, err := slowModeRetryAfter(ctx, tx, &livestreamModel, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to check slow mode: "+err.Error())
 		}
-		c.Logger().Infof("[hitSpam=%d] comment = %s", hitSpam, req.Comment)
-		if hitSpam >= 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, "このコメントがスパム判定されました")
+		if retryAfter > 0 {
+			return newTooManyRequestsError(c, retryAfter, "slow mode is enabled on this livestream")
 		}
 	}
 
+	// スパム判定
+	matcher, err := ngWordMatchers.Get(ctx, &livestreamModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+	}
+	if ngword, hit := matcher.Match(req.Comment); hit {
+		c.Logger().Infof("[hitSpam ng_word_id=%d] comment = %s", ngword.ID, req.Comment)
+		return echo.NewHTTPError(http.StatusBadRequest, "このコメントがスパム判定されました")
+	}
+
 	now := time.Now().Unix()
 	livecommentModel := LivecommentModel{
 		UserID:       userID,
@@ -241,15 +458,41 @@ func postLivecommentHandler(c echo.Context) error {
 	}
 	livecommentModel.ID = livecommentID
 
+	if err := insertTip(ctx, tx, &livestreamModel, &livecommentModel); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert tip: "+err.Error())
+	}
+	if err := addStatistics(ctx, tx, livestreamModel.ID, statisticsDelta{
+		TotalLivecomments: 1,
+		TotalTip:          livecommentModel.Tip,
+		NetTip:            livecommentModel.Tip,
+		MaxTip:            livecommentModel.Tip,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update statistics: "+err.Error())
+	}
+
 	livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
 	}
 
+	if err := completeIdempotentRequest(ctx, tx, idempotencyKey, http.StatusCreated, livecomment); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save idempotent response: "+err.Error())
+	}
+
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	if livecommentModel.Tip > 0 {
+		refreshRankingsAfterCommit(c, livestreamModel.ID)
+	}
+
+	livecommentEventBroker.Publish(livecomment.Livestream.ID, &LivecommentEvent{
+		ID:   livecomment.ID,
+		Type: livecommentEventTypeComment,
+		Data: livecomment,
+	})
+
 	return c.JSON(http.StatusCreated, livecomment)
 }
 
@@ -290,8 +533,14 @@ func reportLivecommentHandler(c echo.Context) error {
 		}
 	}
 
+	if err := verifyUserNotBanned(ctx, tx, c, livestreamModel.UserID, userID); err != nil {
+		return err
+	}
+
+	// 同じライブコメントへの報告を直列化するため、ライブコメントをロックする
+	// 他のライブ配信のライブコメントは、パスのライブ配信に対して報告させない
 	var livecommentModel LivecommentModel
-	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", livecommentID); err != nil {
+	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ? AND livestream_id = ? AND hidden_at = 0 FOR UPDATE", livecommentID, livestreamModel.ID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return echo.NewHTTPError(http.StatusNotFound, "livecomment not found")
 		} else {
@@ -299,14 +548,33 @@ func reportLivecommentHandler(c echo.Context) error {
 		}
 	}
 
+	// 同じユーザからの重複した報告は登録せず、登録済みの報告を返す
+	// 再送されたリクエストと区別せずに扱えるよう、ステータスコードは新規登録時と同じ201とする
+	var reportModel LivecommentReportModel
+	err = tx.GetContext(ctx, &reportModel, "SELECT * FROM livecomment_reports WHERE livecomment_id = ? AND user_id = ?", livecommentID, userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment report: "+err.Error())
+	}
+	if err == nil {
+		report, err := fillLivecommentReportResponse(ctx, tx, reportModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment report: "+err.Error())
+		}
+		if err := tx.Commit(); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		}
+		return c.JSON(http.StatusCreated, report)
+	}
+
 	now := time.Now().Unix()
-	reportModel := LivecommentReportModel{
+	reportModel = LivecommentReportModel{
 		UserID:        int64(userID),
 		LivestreamID:  int64(livestreamID),
 		LivecommentID: int64(livecommentID),
+		Status:        livecommentReportStatusOpen,
 		CreatedAt:     now,
 	}
-	rs, err := tx.NamedExecContext(ctx, "INSERT INTO livecomment_reports(user_id, livestream_id, livecomment_id, created_at) VALUES (:user_id, :livestream_id, :livecomment_id, :created_at)", &reportModel)
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO livecomment_reports(user_id, livestream_id, livecomment_id, status, created_at) VALUES (:user_id, :livestream_id, :livecomment_id, :status, :created_at)", &reportModel)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livecomment report: "+err.Error())
 	}
@@ -315,6 +583,19 @@ func reportLivecommentHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted livecomment report id: "+err.Error())
 	}
 	reportModel.ID = reportID
+	if err := addStatistics(ctx, tx, reportModel.LivestreamID, statisticsDelta{TotalReports: 1}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update statistics: "+err.Error())
+	}
+
+	// 報告したユーザ数が閾値に達したら、ライブコメントを自動で非表示にする
+	hidden, err := hideLivecommentIfReportThresholdExceeded(ctx, tx, &livecommentModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide reported livecomment: "+err.Error())
+	}
+	if hidden {
+		reportModel.Status = livecommentReportStatusActioned
+		reportModel.ResolvedAt = now
+	}
 
 	report, err := fillLivecommentReportResponse(ctx, tx, reportModel)
 	if err != nil {
@@ -324,6 +605,11 @@ func reportLivecommentHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	if hidden {
+		refreshRankingsAfterCommit(c, livecommentModel.LivestreamID)
+		publishModeratedLivecomments(livecommentModel.LivestreamID, 0, []int64{livecommentModel.ID})
+	}
+
 	return c.JSON(http.StatusCreated, report)
 }
 
@@ -357,19 +643,33 @@ func moderateHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	// 配信者自身の配信に対するmoderateなのかを検証
-	var ownedLivestreams []LivestreamModel
-	if err := tx.SelectContext(ctx, &ownedLivestreams, "SELECT * FROM livestreams WHERE id = ? AND user_id = ?", livestreamID, userID); err != nil {
+	// 配信者自身か、コラボレーターによるmoderateなのかを検証
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusBadRequest, "A streamer can't moderate livestreams that other streamers own")
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
 	}
-	if len(ownedLivestreams) == 0 {
+	canModerate, err := canModerateLivestream(ctx, tx, &livestreamModel, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderation permission: "+err.Error())
+	}
+	if !canModerate {
 		return echo.NewHTTPError(http.StatusBadRequest, "A streamer can't moderate livestreams that other streamers own")
 	}
 
-	rs, err := tx.NamedExecContext(ctx, "INSERT INTO ng_words(user_id, livestream_id, word, created_at) VALUES (:user_id, :livestream_id, :word, :created_at)", &NGWord{
-		UserID:       int64(userID),
+	matchType, err := validateNGWord(req.NGWord, req.MatchType)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// コラボレーターによるNGワードも、配信者のNGワードとして登録する
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO ng_words(user_id, livestream_id, word, match_type, created_at) VALUES (:user_id, :livestream_id, :word, :match_type, :created_at)", &NGWord{
+		UserID:       livestreamModel.UserID,
 		LivestreamID: int64(livestreamID),
 		Word:         req.NGWord,
+		MatchType:    matchType,
 		CreatedAt:    time.Now().Unix(),
 	})
 	if err != nil {
@@ -380,42 +680,30 @@ func moderateHandler(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted NG word id: "+err.Error())
 	}
-
-	var ngwords []*NGWord
-	if err := tx.SelectContext(ctx, &ngwords, "SELECT * FROM ng_words WHERE livestream_id = ?", livestreamID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+	if err := insertModerationLog(ctx, tx, &ModerationLogModel{
+		LivestreamID: livestreamModel.ID,
+		UserID:       userID,
+		Action:       moderationActionAddNGWord,
+		NGWordID:     wordID,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert moderation log: "+err.Error())
 	}
 
-	// NGワードにヒットする過去の投稿も全削除する
-	for _, ngword := range ngwords {
-		// ライブコメント一覧取得
-		var livecomments []*LivecommentModel
-		if err := tx.SelectContext(ctx, &livecomments, "SELECT * FROM livecomments"); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomments: "+err.Error())
-		}
-
-		for _, livecomment := range livecomments {
-			query := `
-			DELETE FROM livecomments
-			WHERE
-			id = ? AND
-			livestream_id = ? AND
-			(SELECT COUNT(*)
-			FROM
-			(SELECT ? AS text) AS texts
-			INNER JOIN
-			(SELECT CONCAT('%', ?, '%')	AS pattern) AS patterns
-			ON texts.text LIKE patterns.pattern) >= 1;
-			`
-			if _, err := tx.ExecContext(ctx, query, livecomment.ID, livestreamID, livecomment.Comment, ngword.Word); err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old livecomments that hit spams: "+err.Error())
-			}
-		}
+	// NGワードにヒットする過去の投稿も全て非表示にする
+	moderatedLivecommentIDs, err := moderateLivecomments(ctx, tx, &livestreamModel, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old livecomments that hit spams: "+err.Error())
 	}
 
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
+	ngWordMatchers.Invalidate(int64(livestreamID))
+	if len(moderatedLivecommentIDs) > 0 {
+		refreshRankingsAfterCommit(c, int64(livestreamID))
+	}
+
+	publishModeratedLivecomments(int64(livestreamID), wordID, moderatedLivecommentIDs)
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"word_id": wordID,
@@ -476,6 +764,8 @@ func fillLivecommentReportResponse(ctx context.Context, tx *sqlx.Tx, reportModel
 		ID:          reportModel.ID,
 		Reporter:    reporter,
 		Livecomment: livecomment,
+		Status:      reportModel.Status,
+		ResolvedAt:  reportModel.ResolvedAt,
 		CreatedAt:   reportModel.CreatedAt,
 	}
 	return report, nil
@@ -23,6 +23,8 @@ type ReserveLivestreamRequest struct {
 	ThumbnailUrl string  `json:"thumbnail_url"`
 	StartAt      int64   `json:"start_at"`
 	EndAt        int64   `json:"end_at"`
+	// Collaborators は、コラボレーターとして招待するユーザ名
+	Collaborators []string `json:"collaborators"`
 }
 
 type LivestreamViewerModel struct {
@@ -31,6 +33,27 @@ type LivestreamViewerModel struct {
 	CreatedAt    int64 `db:"created_at" json:"created_at"`
 }
 
+// LivestreamViewerSessionModel は、視聴の開始から終了までの記録
+// livestream_viewers_historyと異なり、退出しても削除しない
+type LivestreamViewerSessionModel struct {
+	ID           int64 `db:"id"`
+	UserID       int64 `db:"user_id"`
+	LivestreamID int64 `db:"livestream_id"`
+	EnteredAt    int64 `db:"entered_at"`
+	// 0の場合は視聴中
+	ExitedAt int64 `db:"exited_at"`
+}
+
+type LivestreamPresence struct {
+	LivestreamID int64 `json:"livestream_id"`
+	// ハートビートが途切れていない視聴者数
+	CurrentViewers int64 `json:"current_viewers"`
+	PeakViewers    int64 `json:"peak_viewers"`
+	PeakAt         int64 `json:"peak_at"`
+	// ハートビートAPIでのみ返す
+	HeartbeatInterval int64 `json:"heartbeat_interval,omitempty"`
+}
+
 type LivestreamModel struct {
 	ID           int64  `db:"id" json:"id"`
 	UserID       int64  `db:"user_id" json:"user_id"`
@@ -52,6 +75,8 @@ type Livestream struct {
 	Tags         []Tag  `json:"tags"`
 	StartAt      int64  `json:"start_at"`
 	EndAt        int64  `json:"end_at"`
+	// Collaborators は、招待を承諾したコラボレーター
+	Collaborators []LivestreamCollaborator `json:"collaborators"`
 }
 
 type LivestreamTagModel struct {
@@ -60,6 +85,26 @@ type LivestreamTagModel struct {
 	TagID        int64 `db:"tag_id" json:"tag_id"`
 }
 
+// UpdateLivestreamRequest は、ライブ配信情報の部分更新リクエスト
+// 指定されなかったフィールドは更新しない
+type UpdateLivestreamRequest struct {
+	Tags         *[]int64 `json:"tags"`
+	Title        *string  `json:"title"`
+	Description  *string  `json:"description"`
+	PlaylistUrl  *string  `json:"playlist_url"`
+	ThumbnailUrl *string  `json:"thumbnail_url"`
+}
+
+type RescheduleLivestreamRequest struct {
+	StartAt int64 `json:"start_at"`
+	EndAt   int64 `json:"end_at"`
+}
+
+var (
+	reservationTermStartAt = time.Date(2023, 11, 25, 1, 0, 0, 0, time.UTC)
+	reservationTermEndAt   = time.Date(2024, 11, 25, 1, 0, 0, 0, time.UTC)
+)
+
 type ReservationSlotModel struct {
 	ID      int64 `db:"id" json:"id"`
 	Slot    int64 `db:"slot" json:"slot"`
@@ -94,12 +139,10 @@ func reserveLivestreamHandler(c echo.Context) error {
 
 	// 2023/11/25 10:00からの１年間の期間内であるかチェック
 	var (
-		termStartAt    = time.Date(2023, 11, 25, 1, 0, 0, 0, time.UTC)
-		termEndAt      = time.Date(2024, 11, 25, 1, 0, 0, 0, time.UTC)
-		reserveStartAt = time.Unix(req.StartAt, 0)
-		reserveEndAt   = time.Unix(req.EndAt, 0)
+		termStartAt = reservationTermStartAt
+		termEndAt   = reservationTermEndAt
 	)
-	if (reserveStartAt.Equal(termEndAt) || reserveStartAt.After(termEndAt)) || (reserveEndAt.Equal(termStartAt) || reserveEndAt.Before(termStartAt)) {
+	if !isReservableTerm(req.StartAt, req.EndAt) {
 		return echo.NewHTTPError(http.StatusBadRequest, "bad reservation time range")
 	}
 
@@ -158,6 +201,16 @@ func reserveLivestreamHandler(c echo.Context) error {
 		}
 	}
 
+	// コラボレーター招待
+	if err := inviteCollaborators(ctx, tx, livestreamModel, req.Collaborators); err != nil {
+		return err
+	}
+
+	// 統計情報を0件で作成しておく
+	if err := addStatistics(ctx, tx, livestreamID, statisticsDelta{}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create statistics: "+err.Error())
+	}
+
 	livestream, err := fillLivestreamResponse(ctx, tx, *livestreamModel)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
@@ -166,14 +219,358 @@ func reserveLivestreamHandler(c echo.Context) error {
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
+	refreshRankingsAfterCommit(c, livestreamID)
 
 	return c.JSON(http.StatusCreated, livestream)
 }
 
+// ライブ配信情報の更新API
+// PATCH /api/livestream/:livestream_id
+func updateLivestreamHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var req *UpdateLivestreamRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ? FOR UPDATE", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+
+	if livestreamModel.UserID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "can't update other streamer's livestream")
+	}
+
+	if req.Title != nil {
+		livestreamModel.Title = *req.Title
+	}
+	if req.Description != nil {
+		livestreamModel.Description = *req.Description
+	}
+	if req.PlaylistUrl != nil {
+		livestreamModel.PlaylistUrl = *req.PlaylistUrl
+	}
+	if req.ThumbnailUrl != nil {
+		livestreamModel.ThumbnailUrl = *req.ThumbnailUrl
+	}
+
+	if _, err := tx.NamedExecContext(ctx, "UPDATE livestreams SET title = :title, description = :description, playlist_url = :playlist_url, thumbnail_url = :thumbnail_url WHERE id = :id", livestreamModel); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livestream: "+err.Error())
+	}
+
+	// タグの置き換え
+	if req.Tags != nil {
+		tagIDs := make([]int64, 0, len(*req.Tags))
+		seen := make(map[int64]struct{}, len(*req.Tags))
+		for _, tagID := range *req.Tags {
+			if _, ok := seen[tagID]; ok {
+				continue
+			}
+			seen[tagID] = struct{}{}
+			tagIDs = append(tagIDs, tagID)
+		}
+
+		if len(tagIDs) > 0 {
+			query, params, err := sqlx.In("SELECT COUNT(*) FROM tags WHERE id IN (?)", tagIDs)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to construct IN query: "+err.Error())
+			}
+			var count int
+			if err := tx.GetContext(ctx, &count, query, params...); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to count tags: "+err.Error())
+			}
+			if count != len(tagIDs) {
+				return echo.NewHTTPError(http.StatusBadRequest, "tags contain unknown tag id")
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM livestream_tags WHERE livestream_id = ?", livestreamModel.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete livestream tags: "+err.Error())
+		}
+		for _, tagID := range tagIDs {
+			if _, err := tx.NamedExecContext(ctx, "INSERT INTO livestream_tags (livestream_id, tag_id) VALUES (:livestream_id, :tag_id)", &LivestreamTagModel{
+				LivestreamID: livestreamModel.ID,
+				TagID:        tagID,
+			}); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream tag: "+err.Error())
+			}
+		}
+	}
+
+	livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, livestream)
+}
+
+// 予約期間内の区間であるか
+func isReservableTerm(startAt, endAt int64) bool {
+	var (
+		reserveStartAt = time.Unix(startAt, 0)
+		reserveEndAt   = time.Unix(endAt, 0)
+	)
+	if reserveStartAt.Equal(reservationTermEndAt) || reserveStartAt.After(reservationTermEndAt) {
+		return false
+	}
+	if reserveEndAt.Equal(reservationTermStartAt) || reserveEndAt.Before(reservationTermStartAt) {
+		return false
+	}
+	return true
+}
+
+// 配信予約のキャンセルAPI
+// DELETE /api/livestream/:livestream_id
+func cancelLivestreamHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getReservedLivestreamForUpdate(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	// チップを受け取ったライブ配信は、売上と返金の記録を残すため取り消せない
+	// NOTE: 並列に投稿されたチップを見落とさないようFOR UPDATEで待つ
+	var tipIDs []int64
+	if err := tx.SelectContext(ctx, &tipIDs, "SELECT id FROM tips WHERE livestream_id = ? LIMIT 1 FOR UPDATE", livestreamModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get tips: "+err.Error())
+	}
+	if len(tipIDs) > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "can't cancel the livestream that has received tips")
+	}
+
+	// 予約枠を返却する
+	// NOTE: 予約と同様に、並列な操作による枠数の不整合を防ぐためFOR UPDATEが必要
+	var slots []*ReservationSlotModel
+	if err := tx.SelectContext(ctx, &slots, "SELECT * FROM reservation_slots WHERE start_at >= ? AND end_at <= ? FOR UPDATE", livestreamModel.StartAt, livestreamModel.EndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE reservation_slots SET slot = slot + 1 WHERE start_at >= ? AND end_at <= ?", livestreamModel.StartAt, livestreamModel.EndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation_slot: "+err.Error())
+	}
+
+	// 配信に紐づくデータも合わせて削除する
+	if err := deleteLivestreamStatistics(ctx, tx, livestreamModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete livestream statistics: "+err.Error())
+	}
+	for _, table := range []string{"livestream_tags", "livestream_collaborators", "livestream_viewers_history", "livestream_viewer_sessions", "livestream_unique_viewers", "livestream_presence", "livecomment_reports", "livecomments", "reactions", "ng_words", "moderation_logs", "livestream_moderation_settings"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE livestream_id = ?", livestreamModel.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete "+table+": "+err.Error())
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM livestreams WHERE id = ?", livestreamModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete livestream: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+	ngWordMatchers.Invalidate(livestreamModel.ID)
+	viewerPresence.Remove(livestreamModel.ID)
+	refreshRankingsAfterCommit(c, livestreamModel.ID)
+	if err := refreshUserRanking(ctx, livestreamModel.UserID); err != nil {
+		c.Logger().Warnf("failed to refresh rankings: %s", err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// 配信予約の日時変更API
+// PUT /api/livestream/:livestream_id/schedule
+func rescheduleLivestreamHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var req *RescheduleLivestreamRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if err := validateRescheduleRequest(time.Now(), req); err != nil {
+		return err
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getReservedLivestreamForUpdate(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	// 変更前と変更後の両方の区間の予約枠をまとめてロックする
+	// NOTE: 予約と同様に、並列な操作による枠数の不整合を防ぐためFOR UPDATEが必要
+	lockStartAt, lockEndAt := livestreamModel.StartAt, livestreamModel.EndAt
+	if req.StartAt < lockStartAt {
+		lockStartAt = req.StartAt
+	}
+	if req.EndAt > lockEndAt {
+		lockEndAt = req.EndAt
+	}
+	var slots []*ReservationSlotModel
+	if err := tx.SelectContext(ctx, &slots, "SELECT * FROM reservation_slots WHERE start_at >= ? AND end_at <= ? FOR UPDATE", lockStartAt, lockEndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+	}
+
+	// 変更前の枠を返却してから、変更後の枠を確保する
+	if _, err := tx.ExecContext(ctx, "UPDATE reservation_slots SET slot = slot + 1 WHERE start_at >= ? AND end_at <= ?", livestreamModel.StartAt, livestreamModel.EndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation_slot: "+err.Error())
+	}
+
+	var minSlot int64
+	if err := tx.GetContext(ctx, &minSlot, "SELECT IFNULL(MIN(slot), 1) FROM reservation_slots WHERE start_at >= ? AND end_at <= ?", req.StartAt, req.EndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+	}
+	if minSlot < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("予約期間 %d ~ %dに対して、予約区間 %d ~ %dが予約できません", reservationTermStartAt.Unix(), reservationTermEndAt.Unix(), req.StartAt, req.EndAt))
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE reservation_slots SET slot = slot - 1 WHERE start_at >= ? AND end_at <= ?", req.StartAt, req.EndAt); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation_slot: "+err.Error())
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE livestreams SET start_at = ?, end_at = ? WHERE id = ?", req.StartAt, req.EndAt, livestreamModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livestream: "+err.Error())
+	}
+	livestreamModel.StartAt = req.StartAt
+	livestreamModel.EndAt = req.EndAt
+
+	livestream, err := fillLivestreamResponse(ctx, tx, *livestreamModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, livestream)
+}
+
+// getReservedLivestreamForUpdate は、配信者自身が予約した未開始のライブ配信を行ロックした上で取得する
+// 返すエラーはecho.NewHTTPErrorなので、呼び出し側ではそのまま返せば良い
+func getReservedLivestreamForUpdate(ctx context.Context, tx *sqlx.Tx, livestreamID int64, userID int64) (*LivestreamModel, error) {
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ? FOR UPDATE", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+
+	if err := verifyReservationChangeable(time.Now(), &livestreamModel, userID); err != nil {
+		return nil, err
+	}
+
+	return &livestreamModel, nil
+}
+
+// verifyReservationChangeable は、配信者自身の未開始のライブ配信であるかを検証する
+// 返すエラーはecho.NewHTTPErrorなので、呼び出し側ではそのまま返せば良い
+func verifyReservationChangeable(now time.Time, livestreamModel *LivestreamModel, userID int64) error {
+	if livestreamModel.UserID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "can't change other streamer's livestream reservation")
+	}
+	if now.Unix() >= livestreamModel.StartAt {
+		return echo.NewHTTPError(http.StatusBadRequest, "can't change the reservation of the livestream that has already started")
+	}
+	return nil
+}
+
+// validateRescheduleRequest は、変更後の日時が予約期間内の未来の区間であるかを検証する
+// 返すエラーはecho.NewHTTPErrorなので、呼び出し側ではそのまま返せば良い
+func validateRescheduleRequest(now time.Time, req *RescheduleLivestreamRequest) error {
+	if req.StartAt >= req.EndAt {
+		return echo.NewHTTPError(http.StatusBadRequest, "start_at must be before end_at")
+	}
+	if req.StartAt <= now.Unix() {
+		return echo.NewHTTPError(http.StatusBadRequest, "start_at must be in the future")
+	}
+	if !isReservableTerm(req.StartAt, req.EndAt) {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad reservation time range")
+	}
+	return nil
+}
+
 func searchLivestreamsHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 	keyTagName := c.QueryParam("tag")
 
+	page, err := parsePageQuery(c)
+	if err != nil {
+		return err
+	}
+
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
@@ -188,7 +585,15 @@ func searchLivestreamsHandler(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get tags: "+err.Error())
 		}
 
-		query, params, err := sqlx.In("SELECT * FROM livestream_tags WHERE tag_id IN (?) ORDER BY livestream_id DESC", tagIDList)
+		query := "SELECT * FROM livestream_tags WHERE tag_id IN (?)"
+		args := []interface{}{tagIDList}
+		if cond, condArgs := page.IDCondition("livestream_id"); cond != "" {
+			query += " AND " + cond
+			args = append(args, condArgs...)
+		}
+		query += " ORDER BY livestream_id " + page.Order() + page.LimitClause()
+
+		query, params, err := sqlx.In(query, args...)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to construct IN query: "+err.Error())
 		}
@@ -207,19 +612,24 @@ func searchLivestreamsHandler(c echo.Context) error {
 		}
 	} else {
 		// 検索条件なし
-		query := `SELECT * FROM livestreams ORDER BY id DESC`
-		if c.QueryParam("limit") != "" {
-			limit, err := strconv.Atoi(c.QueryParam("limit"))
-			if err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
-			}
-			query += fmt.Sprintf(" LIMIT %d", limit)
+		query := `SELECT * FROM livestreams`
+		var args []interface{}
+		if cond, condArgs := page.IDCondition("id"); cond != "" {
+			query += " WHERE " + cond
+			args = append(args, condArgs...)
 		}
+		query += " ORDER BY id " + page.Order() + page.LimitClause()
 
-		if err := tx.SelectContext(ctx, &livestreamModels, query); err != nil {
+		if err := tx.SelectContext(ctx, &livestreamModels, query, args...); err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
 		}
 	}
+	if page.After != nil {
+		// 新しい順に揃える
+		for i, j := 0, len(livestreamModels)-1; i < j; i, j = i+1, j-1 {
+			livestreamModels[i], livestreamModels[j] = livestreamModels[j], livestreamModels[i]
+		}
+	}
 
 	livestreams := make([]Livestream, len(livestreamModels))
 	for i := range livestreamModels {
@@ -234,6 +644,12 @@ func searchLivestreamsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	if n := len(livestreamModels); n > 0 {
+		setNextPageLink(c, page, n,
+			PageCursor{ID: livestreamModels[0].ID},
+			PageCursor{ID: livestreamModels[n-1].ID})
+	}
+
 	return c.JSON(http.StatusOK, livestreams)
 }
 
@@ -350,10 +766,29 @@ func enterLivestreamHandler(c echo.Context) error {
 	if _, err := tx.NamedExecContext(ctx, "INSERT INTO livestream_viewers_history (user_id, livestream_id, created_at) VALUES(:user_id, :livestream_id, :created_at)", viewer); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream_view_history: "+err.Error())
 	}
+	if _, err := tx.NamedExecContext(ctx, "INSERT INTO livestream_viewer_sessions (user_id, livestream_id, entered_at) VALUES(:user_id, :livestream_id, :created_at)", viewer); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream_viewer_session: "+err.Error())
+	}
+	// 初めて視聴するユーザであれば、ユニーク視聴者数に数える
+	// NOTE: 同時に入室したリクエストで二重に数えないよう、一意制約のあるテーブルへの挿入で判定する
+	rs, err := tx.NamedExecContext(ctx, "INSERT IGNORE INTO livestream_unique_viewers (livestream_id, user_id, created_at) VALUES(:livestream_id, :user_id, :created_at)", viewer)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream_unique_viewer: "+err.Error())
+	}
+	delta := statisticsDelta{ViewersCount: 1}
+	if n, err := rs.RowsAffected(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get affected rows: "+err.Error())
+	} else if n == 1 {
+		delta.UniqueViewers = 1
+	}
+	if err := addStatistics(ctx, tx, viewer.LivestreamID, delta); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update statistics: "+err.Error())
+	}
 
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
+	viewerPresence.Touch(viewer.LivestreamID, viewer.UserID, time.Now())
 
 	return c.NoContent(http.StatusOK)
 }
@@ -381,17 +816,98 @@ func exitLivestreamHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	if _, err := tx.ExecContext(ctx, "DELETE FROM livestream_viewers_history WHERE user_id = ? AND livestream_id = ?", userID, livestreamID); err != nil {
+	rs, err := tx.ExecContext(ctx, "DELETE FROM livestream_viewers_history WHERE user_id = ? AND livestream_id = ?", userID, livestreamID)
+	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete livestream_view_history: "+err.Error())
 	}
+	if _, err := tx.ExecContext(ctx, "UPDATE livestream_viewer_sessions SET exited_at = ? WHERE user_id = ? AND livestream_id = ? AND exited_at = 0", time.Now().Unix(), userID, livestreamID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livestream_viewer_session: "+err.Error())
+	}
+	deleted, err := rs.RowsAffected()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get affected rows: "+err.Error())
+	}
+	if deleted > 0 {
+		if err := addStatistics(ctx, tx, int64(livestreamID), statisticsDelta{ViewersCount: -deleted}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update statistics: "+err.Error())
+		}
+	}
 
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
+	viewerPresence.Leave(int64(livestreamID), userID)
 
 	return c.NoContent(http.StatusOK)
 }
 
+// 視聴中のハートビートAPI
+// POST /api/livestream/:livestream_id/heartbeat
+// heartbeat_interval秒ごとに呼び出すこと (途切れた視聴者は一定時間後に同時視聴者数から除かれる)
+func heartbeatLivestreamHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var exists bool
+	if err := dbConn.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM livestreams WHERE id = ?)", livestreamID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+	}
+
+	presence, peakLoaded := viewerPresence.Touch(int64(livestreamID), userID, time.Now())
+	presence, err = currentViewerPresence(ctx, dbConn, presence, peakLoaded)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get viewer presence: "+err.Error())
+	}
+	presence.HeartbeatInterval = int64(presenceHeartbeatInterval / time.Second)
+	return c.JSON(http.StatusOK, presence)
+}
+
+// 現在の同時視聴者数取得API
+// GET /api/livestream/:livestream_id/viewers/current
+func getCurrentViewersHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var exists bool
+	if err := dbConn.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM livestreams WHERE id = ?)", livestreamID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+	}
+
+	presence, peakLoaded := viewerPresence.Current(int64(livestreamID), time.Now())
+	presence, err = currentViewerPresence(ctx, dbConn, presence, peakLoaded)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get viewer presence: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, presence)
+}
+
 func getLivestreamHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 
@@ -459,12 +975,27 @@ func getLivecommentReportsHandler(c echo.Context) error {
 	// existence already check
 	userID := sess.Values[defaultUserIDKey].(int64)
 
-	if livestreamModel.UserID != userID {
+	// 配信者本人と、コラボレーターのみ閲覧できる
+	canModerate, err := canModerateLivestream(ctx, tx, &livestreamModel, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderation permission: "+err.Error())
+	}
+	if !canModerate {
 		return echo.NewHTTPError(http.StatusForbidden, "can't get other streamer's livecomment reports")
 	}
 
+	query := "SELECT * FROM livecomment_reports WHERE livestream_id = ?"
+	args := []interface{}{livestreamID}
+	if status := c.QueryParam("status"); status != "" {
+		if !isValidLivecommentReportStatus(status) {
+			return echo.NewHTTPError(http.StatusBadRequest, "status query parameter must be one of open, dismissed, actioned")
+		}
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+
 	var reportModels []*LivecommentReportModel
-	if err := tx.SelectContext(ctx, &reportModels, "SELECT * FROM livecomment_reports WHERE livestream_id = ?", livestreamID); err != nil {
+	if err := tx.SelectContext(ctx, &reportModels, query, args...); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment reports: "+err.Error())
 	}
 
@@ -485,43 +1016,118 @@ func getLivecommentReportsHandler(c echo.Context) error {
 }
 
 func fillLivestreamResponse(ctx context.Context, tx *sqlx.Tx, livestreamModel LivestreamModel) (Livestream, error) {
-	ownerModel := UserModel{}
-	if err := tx.GetContext(ctx, &ownerModel, "SELECT * FROM users WHERE id = ?", livestreamModel.UserID); err != nil {
-		return Livestream{}, err
-	}
-	owner, err := fillUserResponse(ctx, tx, ownerModel)
+	livestreams, err := fillLivestreamResponses(ctx, tx, []*LivestreamModel{&livestreamModel})
 	if err != nil {
 		return Livestream{}, err
 	}
+	return livestreams[0], nil
+}
+
+// fillLivestreamResponses は、複数のライブ配信について、配信者とタグをまとめて取得して埋める
+func fillLivestreamResponses(ctx context.Context, tx *sqlx.Tx, livestreamModels []*LivestreamModel) ([]Livestream, error) {
+	if len(livestreamModels) == 0 {
+		return []Livestream{}, nil
+	}
 
-	var livestreamTagModels []*LivestreamTagModel
-	if err := tx.SelectContext(ctx, &livestreamTagModels, "SELECT * FROM livestream_tags WHERE livestream_id = ?", livestreamModel.ID); err != nil {
-		return Livestream{}, err
+	ownerIDs := make([]int64, 0, len(livestreamModels))
+	livestreamIDs := make([]int64, len(livestreamModels))
+	for i, livestreamModel := range livestreamModels {
+		ownerIDs = append(ownerIDs, livestreamModel.UserID)
+		livestreamIDs[i] = livestreamModel.ID
 	}
 
-	tags := make([]Tag, len(livestreamTagModels))
-	for i := range livestreamTagModels {
-		tagModel := TagModel{}
-		if err := tx.GetContext(ctx, &tagModel, "SELECT * FROM tags WHERE id = ?", livestreamTagModels[i].TagID); err != nil {
-			return Livestream{}, err
+	query, params, err := sqlx.In("SELECT * FROM users WHERE id IN (?)", ownerIDs)
+	if err != nil {
+		return nil, err
+	}
+	var ownerModels []*UserModel
+	if err := tx.SelectContext(ctx, &ownerModels, query, params...); err != nil {
+		return nil, err
+	}
+	owners := make(map[int64]User, len(ownerModels))
+	for _, ownerModel := range ownerModels {
+		owner, err := fillUserResponse(ctx, tx, *ownerModel)
+		if err != nil {
+			return nil, err
 		}
+		owners[owner.ID] = owner
+	}
+
+	query, params, err = sqlx.In(`
+	SELECT lt.livestream_id AS livestream_id, t.id AS id, t.name AS name
+	FROM livestream_tags lt
+	INNER JOIN tags t ON t.id = lt.tag_id
+	WHERE lt.livestream_id IN (?)
+	ORDER BY lt.id`, livestreamIDs)
+	if err != nil {
+		return nil, err
+	}
+	var livestreamTags []*struct {
+		LivestreamID int64 `db:"livestream_id"`
+		TagModel
+	}
+	if err := tx.SelectContext(ctx, &livestreamTags, query, params...); err != nil {
+		return nil, err
+	}
+	tags := make(map[int64][]Tag, len(livestreamModels))
+	for _, livestreamTag := range livestreamTags {
+		tags[livestreamTag.LivestreamID] = append(tags[livestreamTag.LivestreamID], Tag{
+			ID:   livestreamTag.ID,
+			Name: livestreamTag.Name,
+		})
+	}
 
-		tags[i] = Tag{
-			ID:   tagModel.ID,
-			Name: tagModel.Name,
+	query, params, err = sqlx.In("SELECT * FROM livestream_collaborators WHERE livestream_id IN (?) AND status = ? ORDER BY id", livestreamIDs, collaboratorStatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	var collaboratorModels []*LivestreamCollaboratorModel
+	if err := tx.SelectContext(ctx, &collaboratorModels, query, params...); err != nil {
+		return nil, err
+	}
+	collaborators := make(map[int64][]LivestreamCollaborator, len(livestreamModels))
+	for _, collaboratorModel := range collaboratorModels {
+		userModel := UserModel{}
+		if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ?", collaboratorModel.UserID); err != nil {
+			return nil, err
 		}
+		user, err := fillUserResponse(ctx, tx, userModel)
+		if err != nil {
+			return nil, err
+		}
+		collaborators[collaboratorModel.LivestreamID] = append(collaborators[collaboratorModel.LivestreamID], LivestreamCollaborator{
+			User:            user,
+			TipSharePercent: collaboratorModel.TipSharePercent,
+		})
 	}
 
-	livestream := Livestream{
-		ID:           livestreamModel.ID,
-		Owner:        owner,
-		Title:        livestreamModel.Title,
-		Tags:         tags,
-		Description:  livestreamModel.Description,
-		PlaylistUrl:  livestreamModel.PlaylistUrl,
-		ThumbnailUrl: livestreamModel.ThumbnailUrl,
-		StartAt:      livestreamModel.StartAt,
-		EndAt:        livestreamModel.EndAt,
+	livestreams := make([]Livestream, len(livestreamModels))
+	for i, livestreamModel := range livestreamModels {
+		owner, ok := owners[livestreamModel.UserID]
+		if !ok {
+			return nil, fmt.Errorf("not found owner of livestream: livestream_id=%d", livestreamModel.ID)
+		}
+		livestreamTags, ok := tags[livestreamModel.ID]
+		if !ok {
+			livestreamTags = []Tag{}
+		}
+		livestreamCollaborators, ok := collaborators[livestreamModel.ID]
+		if !ok {
+			livestreamCollaborators = []LivestreamCollaborator{}
+		}
+
+		livestreams[i] = Livestream{
+			ID:            livestreamModel.ID,
+			Owner:         owner,
+			Title:         livestreamModel.Title,
+			Tags:          livestreamTags,
+			Description:   livestreamModel.Description,
+			PlaylistUrl:   livestreamModel.PlaylistUrl,
+			ThumbnailUrl:  livestreamModel.ThumbnailUrl,
+			StartAt:       livestreamModel.StartAt,
+			EndAt:         livestreamModel.EndAt,
+			Collaborators: livestreamCollaborators,
+		}
 	}
-	return livestream, nil
+	return livestreams, nil
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func httpErrorCode(err error) int {
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	return 0
+}
+
+// 予約期間は2023/11/25からの1年間なので、その期間内の時刻で判定する
+var reservationTestNow = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestValidateRescheduleRequest(t *testing.T) {
+	now := reservationTestNow.Unix()
+	hour := int64(60 * 60)
+
+	tests := []struct {
+		name    string
+		startAt int64
+		endAt   int64
+		// 0の場合は成功
+		wantCode int
+	}{
+		{name: "future slot in the term", startAt: now + hour, endAt: now + 2*hour},
+		{name: "start_at equals end_at", startAt: now + hour, endAt: now + hour, wantCode: http.StatusBadRequest},
+		{name: "start_at after end_at", startAt: now + 2*hour, endAt: now + hour, wantCode: http.StatusBadRequest},
+		{name: "start_at now", startAt: now, endAt: now + hour, wantCode: http.StatusBadRequest},
+		{name: "start_at in the past", startAt: now - hour, endAt: now + hour, wantCode: http.StatusBadRequest},
+		{name: "after the term", startAt: reservationTermEndAt.Unix(), endAt: reservationTermEndAt.Unix() + hour, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRescheduleRequest(reservationTestNow, &RescheduleLivestreamRequest{StartAt: tt.startAt, EndAt: tt.endAt})
+			if got := httpErrorCode(err); (err == nil) != (tt.wantCode == 0) || got != tt.wantCode {
+				t.Fatalf("validateRescheduleRequest() = %v, want code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestVerifyReservationChangeable(t *testing.T) {
+	now := reservationTestNow.Unix()
+	livestream := &LivestreamModel{ID: 1, UserID: 1, StartAt: now + 60*60, EndAt: now + 2*60*60}
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		userID int64
+		// 0の場合は成功
+		wantCode int
+	}{
+		{name: "owner before start", now: reservationTestNow, userID: 1},
+		{name: "other user", now: reservationTestNow, userID: 2, wantCode: http.StatusForbidden},
+		{name: "at start", now: time.Unix(livestream.StartAt, 0), userID: 1, wantCode: http.StatusBadRequest},
+		{name: "after start", now: time.Unix(livestream.EndAt, 0), userID: 1, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyReservationChangeable(tt.now, livestream, tt.userID)
+			if got := httpErrorCode(err); (err == nil) != (tt.wantCode == 0) || got != tt.wantCode {
+				t.Fatalf("verifyReservationChangeable() = %v, want code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
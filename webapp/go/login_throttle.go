@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// ユーザ名ごとのロックアウトが始まるまでに許容する連続失敗回数
+	// NOTE: IPアドレスごとには数えない。リバースプロキシ越しでは全てのクライアントが同じIPアドレスに見えるため、
+	// 誰かが失敗を重ねるだけで全ユーザがログインできなくなってしまう
+	loginUsernameFailureThreshold = 5
+
+	loginLockoutBaseDuration = 1 * time.Second
+	loginLockoutMaxDuration  = 15 * time.Minute
+	// 最後の失敗からこの時間が経過したら失敗回数をリセットする
+	loginFailureResetDuration = 15 * time.Minute
+
+	// 記録数がこれを超えたら、失敗回数がリセットされる記録を掃除する
+	loginThrottleSweepThreshold = 10000
+)
+
+type loginFailure struct {
+	count       int
+	lastFailed  time.Time
+	lockedUntil time.Time
+}
+
+// loginThrottler は、ログイン失敗回数を数え、閾値を超えたら指数的に伸びるロックアウトを課す
+type loginThrottler struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailure
+}
+
+var loginAttemptThrottler = newLoginThrottler()
+
+func newLoginThrottler() *loginThrottler {
+	return &loginThrottler{
+		failures: make(map[string]*loginFailure),
+	}
+}
+
+func loginThrottleUsernameKey(username string) string {
+	return "username:" + username
+}
+
+// RetryAfter は、いずれかのキーがロックアウト中であれば、解除までの残り時間を返す
+func (t *loginThrottler) RetryAfter(now time.Time, keys ...string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var retryAfter time.Duration
+	for _, key := range keys {
+		f, ok := t.failures[key]
+		if !ok {
+			continue
+		}
+		if d := f.lockedUntil.Sub(now); d > retryAfter {
+			retryAfter = d
+		}
+	}
+	return retryAfter, retryAfter > 0
+}
+
+// Fail は、ログイン失敗を記録する
+func (t *loginThrottler) Fail(now time.Time, key string, threshold int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.failures[key]
+	if !ok || now.Sub(f.lastFailed) > loginFailureResetDuration {
+		// 存在しないユーザ名での失敗も記録するため、溜まりすぎないよう掃除する
+		if !ok && len(t.failures) >= loginThrottleSweepThreshold {
+			t.sweep(now)
+		}
+		f = &loginFailure{}
+		t.failures[key] = f
+	}
+	f.count++
+	f.lastFailed = now
+
+	if f.count < threshold {
+		return
+	}
+	lockout := loginLockoutBaseDuration
+	for i := threshold; i < f.count && lockout < loginLockoutMaxDuration; i++ {
+		lockout *= 2
+	}
+	if lockout > loginLockoutMaxDuration {
+		lockout = loginLockoutMaxDuration
+	}
+	f.lockedUntil = now.Add(lockout)
+}
+
+// sweep は、最後の失敗からloginFailureResetDurationが経過した記録を削除する
+// ロックアウトはloginLockoutMaxDuration以下なので、これらはロックアウトも解除されている
+func (t *loginThrottler) sweep(now time.Time) {
+	for key, f := range t.failures {
+		if now.Sub(f.lastFailed) > loginFailureResetDuration {
+			delete(t.failures, key)
+		}
+	}
+}
+
+// Succeed は、ログイン成功時に失敗回数をリセットする
+func (t *loginThrottler) Succeed(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		delete(t.failures, key)
+	}
+}
+
+func (t *loginThrottler) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.failures = make(map[string]*loginFailure)
+}
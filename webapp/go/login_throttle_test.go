@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottlerLockout(t *testing.T) {
+	throttler := newLoginThrottler()
+	now := time.Unix(1700000000, 0)
+	key := loginThrottleUsernameKey("alice")
+
+	for i := 0; i < loginUsernameFailureThreshold-1; i++ {
+		throttler.Fail(now, key, loginUsernameFailureThreshold)
+	}
+	if _, locked := throttler.RetryAfter(now, key); locked {
+		t.Fatalf("locked out before reaching the threshold")
+	}
+
+	throttler.Fail(now, key, loginUsernameFailureThreshold)
+	if retryAfter, locked := throttler.RetryAfter(now, key); !locked || retryAfter != loginLockoutBaseDuration {
+		t.Fatalf("RetryAfter() = %s, %v, want %s, true", retryAfter, locked, loginLockoutBaseDuration)
+	}
+	// 他のユーザ名には影響しない
+	if _, locked := throttler.RetryAfter(now, loginThrottleUsernameKey("bob")); locked {
+		t.Fatalf("other username is locked out")
+	}
+
+	throttler.Succeed(key)
+	if _, locked := throttler.RetryAfter(now, key); locked {
+		t.Fatalf("still locked out after success")
+	}
+}
+
+// 存在しないユーザ名での失敗が溜まり続けないこと
+func TestLoginThrottlerSweep(t *testing.T) {
+	throttler := newLoginThrottler()
+	now := time.Unix(1700000000, 0)
+
+	for i := 0; i < loginThrottleSweepThreshold; i++ {
+		throttler.Fail(now, loginThrottleUsernameKey("user"+strconv.Itoa(i)), loginUsernameFailureThreshold)
+	}
+	recent := loginThrottleUsernameKey("recent")
+	throttler.Fail(now.Add(loginFailureResetDuration), recent, loginUsernameFailureThreshold)
+
+	later := now.Add(loginFailureResetDuration + time.Second)
+	throttler.Fail(later, loginThrottleUsernameKey("new"), loginUsernameFailureThreshold)
+
+	if got := len(throttler.failures); got != 2 {
+		t.Fatalf("len(failures) = %d, want 2", got)
+	}
+	if _, ok := throttler.failures[recent]; !ok {
+		t.Fatalf("failure within the reset duration was swept")
+	}
+}
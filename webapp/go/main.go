@@ -4,13 +4,16 @@ package main
 // sqlx的な参考: https://jmoiron.github.io/sqlx/
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"strconv"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
@@ -112,6 +115,18 @@ func initializeHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to initialize: "+err.Error())
 	}
 
+	loginAttemptThrottler.Reset()
+	ngWordMatchers.Reset()
+	livecommentRateLimiter.Reset()
+	viewerPresence.Reset()
+	if err := sessionStore.Reset(c.Request().Context()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reset sessions: "+err.Error())
+	}
+
+	if err := reconcileStatistics(c.Request().Context(), dbConn); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reconcile statistics: "+err.Error())
+	}
+
 	c.Request().Header.Add("Content-Type", "application/json;charset=utf-8")
 	return c.JSON(http.StatusOK, InitializeResponse{
 		Language: "golang",
@@ -144,8 +159,22 @@ func main() {
 	e.GET("/api/user/:username/livestream", getUserLivestreamsHandler)
 	// get livestream
 	e.GET("/api/livestream/:livestream_id", getLivestreamHandler)
+	// update livestream
+	e.PATCH("/api/livestream/:livestream_id", updateLivestreamHandler)
+	// collaborator
+	e.POST("/api/livestream/:livestream_id/collaborator", inviteCollaboratorHandler)
+	e.DELETE("/api/livestream/:livestream_id/collaborator/:username", deleteCollaboratorHandler)
+	e.POST("/api/livestream/:livestream_id/collaborator/accept", acceptCollaborationHandler)
+	e.POST("/api/livestream/:livestream_id/collaborator/decline", declineCollaborationHandler)
+	e.PUT("/api/livestream/:livestream_id/tip_split", putTipSplitHandler)
+	e.GET("/api/user/me/collaborator/invitation", getCollaborationInvitationsHandler)
+	// cancel / reschedule reserved livestream
+	e.DELETE("/api/livestream/:livestream_id", cancelLivestreamHandler)
+	e.PUT("/api/livestream/:livestream_id/schedule", rescheduleLivestreamHandler)
 	// get polling livecomment timeline
 	e.GET("/api/livestream/:livestream_id/livecomment", getLivecommentsHandler)
+	// ライブコメントのストリーミング取得 (Server-Sent Events)
+	e.GET("/api/livestream/:livestream_id/livecomment/stream", streamLivecommentsHandler)
 	// ライブコメント投稿
 	e.POST("/api/livestream/:livestream_id/livecomment", postLivecommentHandler)
 	e.POST("/api/livestream/:livestream_id/reaction", postReactionHandler)
@@ -154,33 +183,81 @@ func main() {
 	// (配信者向け)ライブコメントの報告一覧取得API
 	e.GET("/api/livestream/:livestream_id/report", getLivecommentReportsHandler)
 	e.GET("/api/livestream/:livestream_id/ngwords", getNgwords)
+	e.DELETE("/api/livestream/:livestream_id/ngwords/:ngword_id", deleteNgwordHandler)
 	// ライブコメント報告
 	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/report", reportLivecommentHandler)
 	// 配信者によるモデレーション (NGワード登録)
 	e.POST("/api/livestream/:livestream_id/moderate", moderateHandler)
+	// moderationの監査ログ取得、非表示にされたライブコメントの復元
+	e.GET("/api/livestream/:livestream_id/moderation/log", getModerationLogsHandler)
+	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/restore", restoreLivecommentHandler)
+	e.GET("/api/livestream/:livestream_id/moderation/settings", getModerationSettingsHandler)
+	e.PUT("/api/livestream/:livestream_id/moderation/settings", putModerationSettingsHandler)
+	// ユーザのBAN・タイムアウト
+	e.GET("/api/livestream/:livestream_id/ban", getUserBansHandler)
+	e.POST("/api/livestream/:livestream_id/ban", banUserHandler)
+	e.DELETE("/api/livestream/:livestream_id/ban/:username", unbanUserHandler)
+	e.POST("/api/livestream/:livestream_id/timeout", timeoutUserHandler)
+	e.DELETE("/api/livestream/:livestream_id/timeout/:username", untimeoutUserHandler)
+	// スパム報告への対応
+	e.POST("/api/livestream/:livestream_id/report/:report_id/resolve", resolveLivecommentReportHandler)
 
 	// livestream_viewersにINSERTするため必要
 	// ユーザ視聴開始 (viewer)
 	e.POST("/api/livestream/:livestream_id/enter", enterLivestreamHandler)
 	// ユーザ視聴終了 (viewer)
 	e.DELETE("/api/livestream/:livestream_id/exit", exitLivestreamHandler)
+	// ユーザ視聴中のハートビート (viewer)
+	e.POST("/api/livestream/:livestream_id/heartbeat", heartbeatLivestreamHandler)
+	// 現在の同時視聴者数
+	e.GET("/api/livestream/:livestream_id/viewers/current", getCurrentViewersHandler)
 
 	// user
 	e.POST("/api/register", registerHandler)
 	e.POST("/api/login", loginHandler)
+	e.POST("/api/logout", logoutHandler)
+	// 全端末からログアウト
+	e.POST("/api/logout/all", logoutAllHandler)
 	e.GET("/api/user/me", getMeHandler)
+	e.PATCH("/api/user/me", patchMeHandler)
+	e.POST("/api/user/me/password", changePasswordHandler)
+	// アカウント単位のNGワード
+	e.GET("/api/user/me/ngwords", getAccountNgwordsHandler)
+	e.POST("/api/user/me/ngwords", postAccountNgwordHandler)
+	e.DELETE("/api/user/me/ngwords/:ngword_id", deleteAccountNgwordHandler)
+	e.GET("/api/user/me/ngwords/export", exportAccountNgwordsHandler)
+	e.POST("/api/user/me/ngwords/import", importAccountNgwordsHandler)
+	// 支払ったチップと返金の履歴
+	e.GET("/api/user/me/tips", getMyTipsHandler)
+	// 視聴履歴と、最近視聴した配信者
+	e.GET("/api/user/me/history", getMyWatchHistoryHandler)
+	e.GET("/api/user/me/history/streamers", getRecentlyWatchedStreamersHandler)
 	// フロントエンドで、配信予約のコラボレーターを指定する際に必要
 	e.GET("/api/user/:username", getUserHandler)
 	e.GET("/api/user/:username/statistics", getUserStatisticsHandler)
 	e.GET("/api/user/:username/icon", getIconHandler)
+	// follow
+	e.POST("/api/user/:username/follow", followHandler)
+	e.DELETE("/api/user/:username/follow", unfollowHandler)
+	// フォロー中の配信者のライブ配信一覧
+	e.GET("/api/feed", getFeedHandler)
 	e.POST("/api/icon", postIconHandler)
 
 	// stats
 	// ライブ配信統計情報
 	e.GET("/api/livestream/:livestream_id/statistics", getLivestreamStatisticsHandler)
+	e.GET("/api/livestream/:livestream_id/statistics/timeline", getLivestreamTimelineHandler)
+	// ranking
+	e.GET("/api/ranking/users", getUserRankingHandler)
+	e.GET("/api/ranking/livestreams", getLivestreamRankingHandler)
 
 	// 課金情報
 	e.GET("/api/payment", GetPaymentResult)
+	e.GET("/api/payment/me", getMyPaymentHandler)
+	e.GET("/api/payment/me/settings", getPaymentSettingsHandler)
+	e.PUT("/api/payment/me/settings", putPaymentSettingsHandler)
+	e.GET("/api/payment/me/refunds", getStreamerRefundsHandler)
+	e.POST("/api/payment/me/refunds/:refund_id/resolve", resolveRefundHandler)
 
 	e.HTTPErrorHandler = errorResponseHandler
 
@@ -193,6 +270,30 @@ func main() {
 	defer conn.Close()
 	dbConn = conn
 
+	// 統計情報カウンタの再構築のみ行う
+	// ./isupipe reconcile-stats
+	if len(os.Args) > 1 && os.Args[1] == "reconcile-stats" {
+		if err := reconcileStatistics(context.Background(), conn); err != nil {
+			e.Logger.Errorf("failed to reconcile statistics: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := loadRankings(context.Background(), conn); err != nil {
+		e.Logger.Errorf("failed to load rankings: %v", err)
+		os.Exit(1)
+	}
+	go runViewerPresenceFlusher(context.Background(), conn, e.Logger)
+	go runIdempotencyKeyPurger(context.Background(), conn, e.Logger)
+
+	store, err := newSessionStore(os.Getenv(sessionStoreEnvKey), conn)
+	if err != nil {
+		e.Logger.Errorf("failed to initialize session store: %v", err)
+		os.Exit(1)
+	}
+	sessionStore = store
+
 	subdomainAddr, ok := os.LookupEnv(powerDNSSubdomainAddressEnvKey)
 	if !ok {
 		e.Logger.Errorf("environ %s must be provided", powerDNSSubdomainAddressEnvKey)
@@ -208,6 +309,16 @@ func main() {
 	}
 }
 
+// newTooManyRequestsError は、Retry-Afterヘッダを付与した上で429エラーを返す
+func newTooManyRequestsError(c echo.Context, retryAfter time.Duration, message string) error {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	return echo.NewHTTPError(http.StatusTooManyRequests, message)
+}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
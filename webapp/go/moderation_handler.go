@@ -0,0 +1,643 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	moderationActionAddNGWord          = "add_ng_word"
+	moderationActionDeleteNGWord       = "delete_ng_word"
+	moderationActionHideLivecomment    = "hide_livecomment"
+	moderationActionRestoreLivecomment = "restore_livecomment"
+	moderationActionDismissReport      = "dismiss_report"
+
+	// moderationActorSystem は、自動で行われたmoderation操作の操作ユーザ
+	moderationActorSystem = 0
+
+	// livecommentHiddenReasonNGWord は、NGワードにヒットしたため非表示にされたことを表す
+	livecommentHiddenReasonNGWord = "ng_word"
+	// livecommentHiddenReasonReport は、スパム報告に対応して配信者が非表示にしたことを表す
+	livecommentHiddenReasonReport = "report"
+	// livecommentHiddenReasonReportThreshold は、報告したユーザ数が閾値に達したため自動で非表示にされたことを表す
+	livecommentHiddenReasonReportThreshold = "report_threshold"
+
+	livecommentReportStatusOpen      = "open"
+	livecommentReportStatusDismissed = "dismissed"
+	livecommentReportStatusActioned  = "actioned"
+)
+
+func isValidLivecommentReportStatus(status string) bool {
+	switch status {
+	case livecommentReportStatusOpen, livecommentReportStatusDismissed, livecommentReportStatusActioned:
+		return true
+	default:
+		return false
+	}
+}
+
+type ModerationSettingsModel struct {
+	LivestreamID            int64 `db:"livestream_id"`
+	ReportHideThreshold     int64 `db:"report_hide_threshold"`
+	SlowModeIntervalSeconds int64 `db:"slow_mode_interval_seconds"`
+}
+
+type ModerationSettings struct {
+	// ReportHideThreshold は、この人数のユーザから報告されたライブコメントを自動で非表示にする。0の場合は無効
+	ReportHideThreshold int64 `json:"report_hide_threshold"`
+	// SlowModeIntervalSeconds は、同じユーザがライブコメントを投稿できる最小間隔(秒)。0の場合は無効
+	// チップ付きのライブコメントと、配信者・コラボレーターのライブコメントは対象外
+	SlowModeIntervalSeconds int64 `json:"slow_mode_interval_seconds"`
+}
+
+type ResolveLivecommentReportRequest struct {
+	// Status は dismissed か actioned のいずれか
+	Status string `json:"status"`
+}
+
+type ModerationLogModel struct {
+	ID            int64  `db:"id"`
+	LivestreamID  int64  `db:"livestream_id"`
+	UserID        int64  `db:"user_id"`
+	Action        string `db:"action"`
+	LivecommentID int64  `db:"livecomment_id"`
+	NGWordID      int64  `db:"ng_word_id"`
+	TargetUserID  int64  `db:"target_user_id"`
+	Reason        string `db:"reason"`
+	CreatedAt     int64  `db:"created_at"`
+}
+
+type ModerationLog struct {
+	ID           int64 `json:"id"`
+	LivestreamID int64 `json:"livestream_id"`
+	// User は、自動で行われた操作の場合は設定しない
+	User   *User  `json:"user,omitempty"`
+	Action string `json:"action"`
+	// Livecomment は、ライブコメントに対する操作の場合のみ設定する
+	Livecomment *Livecomment `json:"livecomment,omitempty"`
+	NGWordID    int64        `json:"ng_word_id,omitempty"`
+	// TargetUser は、ユーザに対する操作の場合のみ設定する
+	TargetUser *User  `json:"target_user,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+func insertModerationLog(ctx context.Context, tx *sqlx.Tx, logModel *ModerationLogModel) error {
+	if logModel.CreatedAt == 0 {
+		logModel.CreatedAt = time.Now().Unix()
+	}
+	_, err := tx.NamedExecContext(ctx, "INSERT INTO moderation_logs (livestream_id, user_id, action, livecomment_id, ng_word_id, target_user_id, reason, created_at) VALUES (:livestream_id, :user_id, :action, :livecomment_id, :ng_word_id, :target_user_id, :reason, :created_at)", logModel)
+	return err
+}
+
+// hideLivecomment は、ライブコメントを非表示にし、監査ログに記録する
+// チップは配信者の返金ポリシーに従って処理するため、ライブコメント自体は削除しない
+func hideLivecomment(ctx context.Context, tx *sqlx.Tx, livecommentModel *LivecommentModel, actorID int64, reason string, ngWordID int64) error {
+	now := time.Now().Unix()
+	if _, err := tx.ExecContext(ctx, "UPDATE livecomments SET hidden_at = ?, hidden_reason = ?, hidden_by_ng_word_id = ? WHERE id = ?", now, reason, ngWordID, livecommentModel.ID); err != nil {
+		return err
+	}
+	withheld, err := applyRefundPolicy(ctx, tx, livecommentModel)
+	if err != nil {
+		return err
+	}
+	if err := addStatistics(ctx, tx, livecommentModel.LivestreamID, statisticsDelta{TotalLivecomments: -1, NetTip: -withheld}); err != nil {
+		return err
+	}
+	return insertModerationLog(ctx, tx, &ModerationLogModel{
+		LivestreamID:  livecommentModel.LivestreamID,
+		UserID:        actorID,
+		Action:        moderationActionHideLivecomment,
+		LivecommentID: livecommentModel.ID,
+		NGWordID:      ngWordID,
+		Reason:        reason,
+		CreatedAt:     now,
+	})
+}
+
+// 監査ログ取得API
+// GET /api/livestream/:livestream_id/moderation/log
+// 配信者のみが参照でき、アカウント単位のNGワードに対する操作も含める
+func getModerationLogsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	page, err := parsePageQuery(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT * FROM moderation_logs WHERE (livestream_id = ? OR (livestream_id = ? AND user_id = ?))"
+	args := []interface{}{livestreamModel.ID, accountNGWordLivestreamID, livestreamModel.UserID}
+	if cond, condArgs := page.IDCondition("id"); cond != "" {
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	query += fmt.Sprintf(" ORDER BY id %s", page.Order())
+	query += page.LimitClause()
+
+	logModels := []*ModerationLogModel{}
+	if err := tx.SelectContext(ctx, &logModels, query, args...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get moderation logs: "+err.Error())
+	}
+	if page.After != nil {
+		// 新しい順に揃える
+		for i, j := 0, len(logModels)-1; i < j; i, j = i+1, j-1 {
+			logModels[i], logModels[j] = logModels[j], logModels[i]
+		}
+	}
+
+	logs := make([]ModerationLog, len(logModels))
+	for i := range logModels {
+		log, err := fillModerationLogResponse(ctx, tx, logModels[i])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill moderation log: "+err.Error())
+		}
+		logs[i] = log
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	if n := len(logModels); n > 0 {
+		setNextPageLink(c, page, n, PageCursor{ID: logModels[0].ID}, PageCursor{ID: logModels[n-1].ID})
+	}
+
+	return c.JSON(http.StatusOK, logs)
+}
+
+// 非表示にされたライブコメントの復元API
+// POST /api/livestream/:livestream_id/livecomment/:livecomment_id/restore
+func restoreLivecommentHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+	livecommentID, err := strconv.Atoi(c.Param("livecomment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livecomment_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	var livecommentModel LivecommentModel
+	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ? AND livestream_id = ? FOR UPDATE", livecommentID, livestreamModel.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livecomment not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+	}
+	if livecommentModel.HiddenAt == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "livecomment is not hidden")
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE livecomments SET hidden_at = 0, hidden_reason = '', hidden_by_ng_word_id = 0 WHERE id = ?", livecommentModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore livecomment: "+err.Error())
+	}
+	released, err := releaseEscrowedRefund(ctx, tx, livecommentModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to release escrowed tip: "+err.Error())
+	}
+	if err := addStatistics(ctx, tx, livestreamModel.ID, statisticsDelta{TotalLivecomments: 1, NetTip: released}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update statistics: "+err.Error())
+	}
+	if err := insertModerationLog(ctx, tx, &ModerationLogModel{
+		LivestreamID:  livestreamModel.ID,
+		UserID:        userID,
+		Action:        moderationActionRestoreLivecomment,
+		LivecommentID: livecommentModel.ID,
+		NGWordID:      livecommentModel.HiddenByNGWordID,
+		Reason:        livecommentModel.HiddenReason,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert moderation log: "+err.Error())
+	}
+
+	livecommentModel.HiddenAt = 0
+	livecommentModel.HiddenReason = ""
+	livecommentModel.HiddenByNGWordID = 0
+	livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	refreshRankingsAfterCommit(c, livestreamModel.ID)
+
+	// 復元したライブコメントは過去のものなので、SSEのidは送出しない
+	livecommentEventBroker.Publish(livestreamModel.ID, &LivecommentEvent{
+		Type: livecommentEventTypeRestore,
+		Data: livecomment,
+	})
+
+	return c.JSON(http.StatusOK, livecomment)
+}
+
+func fillModerationLogResponse(ctx context.Context, tx *sqlx.Tx, logModel *ModerationLogModel) (ModerationLog, error) {
+	log := ModerationLog{
+		ID:           logModel.ID,
+		LivestreamID: logModel.LivestreamID,
+		Action:       logModel.Action,
+		NGWordID:     logModel.NGWordID,
+		Reason:       logModel.Reason,
+		CreatedAt:    logModel.CreatedAt,
+	}
+
+	if logModel.UserID != moderationActorSystem {
+		actorModel := UserModel{}
+		if err := tx.GetContext(ctx, &actorModel, "SELECT * FROM users WHERE id = ?", logModel.UserID); err != nil {
+			return ModerationLog{}, err
+		}
+		actor, err := fillUserResponse(ctx, tx, actorModel)
+		if err != nil {
+			return ModerationLog{}, err
+		}
+		log.User = &actor
+	}
+
+	if logModel.LivecommentID != 0 {
+		livecommentModel := LivecommentModel{}
+		if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", logModel.LivecommentID); err != nil {
+			return ModerationLog{}, err
+		}
+		livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
+		if err != nil {
+			return ModerationLog{}, err
+		}
+		log.Livecomment = &livecomment
+	}
+
+	if logModel.TargetUserID != 0 {
+		targetModel := UserModel{}
+		if err := tx.GetContext(ctx, &targetModel, "SELECT * FROM users WHERE id = ?", logModel.TargetUserID); err != nil {
+			return ModerationLog{}, err
+		}
+		target, err := fillUserResponse(ctx, tx, targetModel)
+		if err != nil {
+			return ModerationLog{}, err
+		}
+		log.TargetUser = &target
+	}
+
+	return log, nil
+}
+
+func getModerationSettings(ctx context.Context, tx *sqlx.Tx, livestreamID int64) (*ModerationSettingsModel, error) {
+	settings := ModerationSettingsModel{LivestreamID: livestreamID}
+	if err := tx.GetContext(ctx, &settings, "SELECT * FROM livestream_moderation_settings WHERE livestream_id = ?", livestreamID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// slowModeRetryAfter は、スローモード中の配信でユーザが次にライブコメントを投稿できるまでの時間を返す
+// 投稿できる場合は0を返す
+func slowModeRetryAfter(ctx context.Context, tx *sqlx.Tx, livestreamModel *LivestreamModel, userID int64) (time.Duration, error) {
+	settings, err := getModerationSettings(ctx, tx, livestreamModel.ID)
+	if err != nil {
+		return 0, err
+	}
+	if settings.SlowModeIntervalSeconds <= 0 {
+		return 0, nil
+	}
+	canModerate, err := canModerateLivestream(ctx, tx, livestreamModel, userID)
+	if err != nil {
+		return 0, err
+	}
+	if canModerate {
+		return 0, nil
+	}
+
+	var lastPostedAt int64
+	if err := tx.GetContext(ctx, &lastPostedAt, "SELECT IFNULL(MAX(created_at), 0) FROM livecomments WHERE livestream_id = ? AND user_id = ?", livestreamModel.ID, userID); err != nil {
+		return 0, err
+	}
+	nextPostableAt := time.Unix(lastPostedAt+settings.SlowModeIntervalSeconds, 0)
+	if retryAfter := time.Until(nextPostableAt); retryAfter > 0 {
+		return retryAfter, nil
+	}
+	return 0, nil
+}
+
+// hideLivecommentIfReportThresholdExceeded は、未対応の報告をしたユーザ数が閾値に達していれば、
+// ライブコメントを非表示にして報告を対応済みにする
+func hideLivecommentIfReportThresholdExceeded(ctx context.Context, tx *sqlx.Tx, livecommentModel *LivecommentModel) (bool, error) {
+	settings, err := getModerationSettings(ctx, tx, livecommentModel.LivestreamID)
+	if err != nil {
+		return false, err
+	}
+	if settings.ReportHideThreshold <= 0 {
+		return false, nil
+	}
+
+	var reporters int64
+	if err := tx.GetContext(ctx, &reporters, "SELECT COUNT(DISTINCT user_id) FROM livecomment_reports WHERE livecomment_id = ? AND status = ?", livecommentModel.ID, livecommentReportStatusOpen); err != nil {
+		return false, err
+	}
+	if reporters < settings.ReportHideThreshold {
+		return false, nil
+	}
+
+	if err := hideLivecomment(ctx, tx, livecommentModel, moderationActorSystem, livecommentHiddenReasonReportThreshold, 0); err != nil {
+		return false, err
+	}
+	if err := resolveLivecommentReports(ctx, tx, livecommentModel, livecommentReportStatusActioned); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// resolveLivecommentReports は、ライブコメントに対する未対応の報告を全て指定の状態にする
+func resolveLivecommentReports(ctx context.Context, tx *sqlx.Tx, livecommentModel *LivecommentModel, status string) error {
+	rs, err := tx.ExecContext(ctx, "UPDATE livecomment_reports SET status = ?, resolved_at = ? WHERE livecomment_id = ? AND status = ?", status, time.Now().Unix(), livecommentModel.ID, livecommentReportStatusOpen)
+	if err != nil {
+		return err
+	}
+	resolved, err := rs.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if resolved == 0 {
+		return nil
+	}
+	return addStatistics(ctx, tx, livecommentModel.LivestreamID, statisticsDelta{TotalReports: -resolved})
+}
+
+// スパム報告の対応API
+// POST /api/livestream/:livestream_id/report/:report_id/resolve
+// 同じライブコメントに対する未対応の報告もまとめて対応済みにする
+// actionedの場合はライブコメントを非表示にする
+func resolveLivecommentReportHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+	reportID, err := strconv.Atoi(c.Param("report_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "report_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *ResolveLivecommentReportRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.Status != livecommentReportStatusDismissed && req.Status != livecommentReportStatusActioned {
+		return echo.NewHTTPError(http.StatusBadRequest, "status must be dismissed or actioned")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+	canModerate, err := canModerateLivestream(ctx, tx, &livestreamModel, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderation permission: "+err.Error())
+	}
+	if !canModerate {
+		return echo.NewHTTPError(http.StatusForbidden, "can't resolve other streamer's livecomment reports")
+	}
+
+	var reportModel LivecommentReportModel
+	if err := tx.GetContext(ctx, &reportModel, "SELECT * FROM livecomment_reports WHERE id = ? AND livestream_id = ?", reportID, livestreamModel.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found livecomment report that has the given id")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment report: "+err.Error())
+	}
+
+	var livecommentModel LivecommentModel
+	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ? FOR UPDATE", reportModel.LivecommentID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+	}
+	if err := tx.GetContext(ctx, &reportModel, "SELECT * FROM livecomment_reports WHERE id = ?", reportModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment report: "+err.Error())
+	}
+	if reportModel.Status != livecommentReportStatusOpen {
+		return echo.NewHTTPError(http.StatusConflict, "livecomment report is already resolved")
+	}
+
+	if err := resolveLivecommentReports(ctx, tx, &livecommentModel, req.Status); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve livecomment reports: "+err.Error())
+	}
+	hidden := false
+	if req.Status == livecommentReportStatusActioned {
+		if livecommentModel.HiddenAt == 0 {
+			if err := hideLivecomment(ctx, tx, &livecommentModel, userID, livecommentHiddenReasonReport, 0); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to hide livecomment: "+err.Error())
+			}
+			hidden = true
+		}
+	} else {
+		if err := insertModerationLog(ctx, tx, &ModerationLogModel{
+			LivestreamID:  livestreamModel.ID,
+			UserID:        userID,
+			Action:        moderationActionDismissReport,
+			LivecommentID: livecommentModel.ID,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert moderation log: "+err.Error())
+		}
+	}
+
+	if err := tx.GetContext(ctx, &reportModel, "SELECT * FROM livecomment_reports WHERE id = ?", reportModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment report: "+err.Error())
+	}
+	report, err := fillLivecommentReportResponse(ctx, tx, reportModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment report: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	if hidden {
+		refreshRankingsAfterCommit(c, livestreamModel.ID)
+		publishModeratedLivecomments(livestreamModel.ID, 0, []int64{livecommentModel.ID})
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// moderation設定取得API
+// GET /api/livestream/:livestream_id/moderation/settings
+func getModerationSettingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+	settingsModel, err := getModerationSettings(ctx, tx, livestreamModel.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get moderation settings: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, ModerationSettings{
+		ReportHideThreshold:     settingsModel.ReportHideThreshold,
+		SlowModeIntervalSeconds: settingsModel.SlowModeIntervalSeconds,
+	})
+}
+
+// moderation設定更新API
+// PUT /api/livestream/:livestream_id/moderation/settings
+func putModerationSettingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *ModerationSettings
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.ReportHideThreshold < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "report_hide_threshold must not be negative")
+	}
+	if req.SlowModeIntervalSeconds < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "slow_mode_interval_seconds must not be negative")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	livestreamModel, err := getOwnedLivestream(ctx, tx, int64(livestreamID), userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.NamedExecContext(ctx, "INSERT INTO livestream_moderation_settings (livestream_id, report_hide_threshold, slow_mode_interval_seconds) VALUES (:livestream_id, :report_hide_threshold, :slow_mode_interval_seconds) ON DUPLICATE KEY UPDATE report_hide_threshold = VALUES(report_hide_threshold), slow_mode_interval_seconds = VALUES(slow_mode_interval_seconds)", &ModerationSettingsModel{
+		LivestreamID:            livestreamModel.ID,
+		ReportHideThreshold:     req.ReportHideThreshold,
+		SlowModeIntervalSeconds: req.SlowModeIntervalSeconds,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update moderation settings: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, req)
+}
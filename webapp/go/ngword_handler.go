@@ -0,0 +1,604 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// accountNGWordLivestreamID は、配信者の全ての配信に適用するNGワードのlivestream_id
+	accountNGWordLivestreamID = 0
+
+	ngWordScopeLivestream = "livestream"
+	ngWordScopeAccount    = "account"
+
+	ngWordListFormatJSON = "json"
+	ngWordListFormatCSV  = "csv"
+)
+
+// NGWordListEntry は、NGワードのインポート・エクスポートに用いる1件分のNGワード
+type NGWordListEntry struct {
+	Word      string `json:"word"`
+	MatchType string `json:"match_type"`
+}
+
+type ImportNGWordsResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// fillNGWordScopes は、NGワードが配信単位かアカウント単位かをScopeに設定する
+func fillNGWordScopes(ngwords []*NGWord) {
+	for _, ngword := range ngwords {
+		if ngword.LivestreamID == accountNGWordLivestreamID {
+			ngword.Scope = ngWordScopeAccount
+		} else {
+			ngword.Scope = ngWordScopeLivestream
+		}
+	}
+}
+
+// getEffectiveNGWords は、配信に適用される配信単位・アカウント単位のNGワードを返す
+func getEffectiveNGWords(ctx context.Context, q sqlx.QueryerContext, livestreamModel *LivestreamModel) ([]*NGWord, error) {
+	ngwords := []*NGWord{}
+	if err := sqlx.SelectContext(ctx, q, &ngwords, "SELECT * FROM ng_words WHERE user_id = ? AND livestream_id IN (?, ?) ORDER BY created_at DESC", livestreamModel.UserID, accountNGWordLivestreamID, livestreamModel.ID); err != nil {
+		return nil, err
+	}
+	fillNGWordScopes(ngwords)
+	return ngwords, nil
+}
+
+// validateNGWord は、登録しようとしているNGワードを検証し、match_typeの省略時はsubstringとする
+func validateNGWord(word string, matchType string) (string, error) {
+	if word == "" {
+		return "", errors.New("NG word must not be empty")
+	}
+	if matchType == "" {
+		matchType = ngWordMatchTypeSubstring
+	}
+	if !isValidNGWordMatchType(matchType) {
+		return "", errors.New("match_type must be one of substring, glob, regex")
+	}
+	if matchType != ngWordMatchTypeSubstring {
+		if _, err := compileNGWordPattern(word, matchType); err != nil {
+			return "", fmt.Errorf("invalid NG word pattern: %w", err)
+		}
+	}
+	return matchType, nil
+}
+
+// moderateLivecomments は、配信に適用されるNGワードにヒットする過去の投稿を非表示にし、非表示にしたライブコメントのIDを返す
+func moderateLivecomments(ctx context.Context, tx *sqlx.Tx, livestreamModel *LivestreamModel, actorID int64) ([]int64, error) {
+	ngwords, err := getEffectiveNGWords(ctx, tx, livestreamModel)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := newNGWordMatcher(ngwords)
+	if err != nil {
+		return nil, err
+	}
+
+	var livecomments []*LivecommentModel
+	if err := tx.SelectContext(ctx, &livecomments, "SELECT * FROM livecomments WHERE livestream_id = ? AND hidden_at = 0", livestreamModel.ID); err != nil {
+		return nil, err
+	}
+
+	var moderatedLivecommentIDs []int64
+	for _, livecomment := range livecomments {
+		ngword, hit := matcher.Match(livecomment.Comment)
+		if !hit {
+			continue
+		}
+		if err := hideLivecomment(ctx, tx, livecomment, actorID, livecommentHiddenReasonNGWord, ngword.ID); err != nil {
+			return nil, err
+		}
+		moderatedLivecommentIDs = append(moderatedLivecommentIDs, livecomment.ID)
+	}
+	return moderatedLivecommentIDs, nil
+}
+
+// moderateAllLivecomments は、配信者の全ての配信に対してmoderateLivecommentsを行い、配信IDごとに非表示にしたライブコメントのIDを返す
+func moderateAllLivecomments(ctx context.Context, tx *sqlx.Tx, userID int64) (map[int64][]int64, error) {
+	var livestreamModels []*LivestreamModel
+	if err := tx.SelectContext(ctx, &livestreamModels, "SELECT * FROM livestreams WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	moderated := make(map[int64][]int64)
+	for _, livestreamModel := range livestreamModels {
+		livecommentIDs, err := moderateLivecomments(ctx, tx, livestreamModel, userID)
+		if err != nil {
+			return nil, err
+		}
+		if len(livecommentIDs) > 0 {
+			moderated[livestreamModel.ID] = livecommentIDs
+		}
+	}
+	return moderated, nil
+}
+
+func publishModeratedLivecomments(livestreamID int64, ngWordID int64, livecommentIDs []int64) {
+	if len(livecommentIDs) == 0 {
+		return
+	}
+	livecommentEventBroker.Publish(livestreamID, &LivecommentEvent{
+		Type: livecommentEventTypeModerate,
+		Data: &ModeratedLivecommentsEvent{
+			NGWordID:       ngWordID,
+			LivecommentIDs: livecommentIDs,
+		},
+	})
+}
+
+// NGワード削除API
+// DELETE /api/livestream/:livestream_id/ngwords/:ngword_id
+// 配信単位のNGワードは配信者とコラボレーター、アカウント単位のNGワードは配信者のみ削除できる
+func deleteNgwordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+	ngWordID, err := strconv.Atoi(c.Param("ngword_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "ngword_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+	canModerate, err := canModerateLivestream(ctx, tx, &livestreamModel, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check moderation permission: "+err.Error())
+	}
+	if !canModerate {
+		return echo.NewHTTPError(http.StatusForbidden, "can't delete NG words of other streamer's livestream")
+	}
+
+	var ngword NGWord
+	if err := tx.GetContext(ctx, &ngword, "SELECT * FROM ng_words WHERE id = ? AND user_id = ? AND livestream_id IN (?, ?)", ngWordID, livestreamModel.UserID, accountNGWordLivestreamID, livestreamModel.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found NG word that has the given id")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG word: "+err.Error())
+	}
+	if ngword.LivestreamID == accountNGWordLivestreamID && livestreamModel.UserID != userID {
+		return echo.NewHTTPError(http.StatusForbidden, "only the streamer can delete account-wide NG words")
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ng_words WHERE id = ?", ngword.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete NG word: "+err.Error())
+	}
+	if err := insertModerationLog(ctx, tx, &ModerationLogModel{
+		LivestreamID: ngword.LivestreamID,
+		UserID:       userID,
+		Action:       moderationActionDeleteNGWord,
+		NGWordID:     ngword.ID,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert moderation log: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+	if ngword.LivestreamID == accountNGWordLivestreamID {
+		ngWordMatchers.InvalidateByUserID(ngword.UserID)
+	} else {
+		ngWordMatchers.Invalidate(ngword.LivestreamID)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// アカウント単位のNGワード一覧取得API
+// GET /api/user/me/ngwords
+func getAccountNgwordsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	ngwords, err := getAccountNGWords(ctx, tx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, ngwords)
+}
+
+// アカウント単位のNGワード登録API
+// POST /api/user/me/ngwords
+// 登録したNGワードは、配信者の全ての配信に適用される
+func postAccountNgwordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *ModerateRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	matchType, err := validateNGWord(req.NGWord, req.MatchType)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	ngword := NGWord{
+		UserID:       userID,
+		LivestreamID: accountNGWordLivestreamID,
+		Word:         req.NGWord,
+		MatchType:    matchType,
+		Scope:        ngWordScopeAccount,
+		CreatedAt:    time.Now().Unix(),
+	}
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO ng_words(user_id, livestream_id, word, match_type, created_at) VALUES (:user_id, :livestream_id, :word, :match_type, :created_at)", &ngword)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert new NG word: "+err.Error())
+	}
+	ngword.ID, err = rs.LastInsertId()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted NG word id: "+err.Error())
+	}
+	if err := insertModerationLog(ctx, tx, &ModerationLogModel{
+		LivestreamID: accountNGWordLivestreamID,
+		UserID:       userID,
+		Action:       moderationActionAddNGWord,
+		NGWordID:     ngword.ID,
+		CreatedAt:    ngword.CreatedAt,
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert moderation log: "+err.Error())
+	}
+
+	// NGワードにヒットする過去の投稿も、全ての配信で非表示にする
+	moderated, err := moderateAllLivecomments(ctx, tx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old livecomments that hit spams: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+	ngWordMatchers.InvalidateByUserID(userID)
+
+	for livestreamID, livecommentIDs := range moderated {
+		refreshRankingsAfterCommit(c, livestreamID)
+		publishModeratedLivecomments(livestreamID, ngword.ID, livecommentIDs)
+	}
+
+	return c.JSON(http.StatusCreated, ngword)
+}
+
+// アカウント単位のNGワード削除API
+// DELETE /api/user/me/ngwords/:ngword_id
+func deleteAccountNgwordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	ngWordID, err := strconv.Atoi(c.Param("ngword_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "ngword_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	rs, err := tx.ExecContext(ctx, "DELETE FROM ng_words WHERE id = ? AND user_id = ? AND livestream_id = ?", ngWordID, userID, accountNGWordLivestreamID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete NG word: "+err.Error())
+	}
+	deleted, err := rs.RowsAffected()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get deleted NG words count: "+err.Error())
+	}
+	if deleted == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "not found NG word that has the given id")
+	}
+	if err := insertModerationLog(ctx, tx, &ModerationLogModel{
+		LivestreamID: accountNGWordLivestreamID,
+		UserID:       userID,
+		Action:       moderationActionDeleteNGWord,
+		NGWordID:     int64(ngWordID),
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert moderation log: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+	ngWordMatchers.InvalidateByUserID(userID)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// アカウント単位のNGワードのエクスポートAPI
+// GET /api/user/me/ngwords/export?format=json|csv
+func exportAccountNgwordsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = ngWordListFormatJSON
+	}
+	if format != ngWordListFormatJSON && format != ngWordListFormatCSV {
+		return echo.NewHTTPError(http.StatusBadRequest, "format query parameter must be json or csv")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	ngwords, err := getAccountNGWords(ctx, tx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	entries := make([]NGWordListEntry, len(ngwords))
+	for i := range ngwords {
+		entries[i] = NGWordListEntry{
+			Word:      ngwords[i].Word,
+			MatchType: ngwords[i].MatchType,
+		}
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="ngwords.%s"`, format))
+	if format == ngWordListFormatJSON {
+		return c.JSON(http.StatusOK, entries)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=UTF-8")
+	c.Response().WriteHeader(http.StatusOK)
+	w := csv.NewWriter(c.Response())
+	if err := w.Write([]string{"word", "match_type"}); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := w.Write([]string{entry.Word, entry.MatchType}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// アカウント単位のNGワードのインポートAPI
+// POST /api/user/me/ngwords/import
+// Content-Typeがtext/csvの場合はCSV、それ以外はJSONとして読み込む
+// 登録済みのNGワードと重複するものはスキップする
+func importAccountNgwordsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var entries []NGWordListEntry
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), "text/csv") {
+		parsed, err := parseNGWordListCSV(c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse the request body as csv: "+err.Error())
+		}
+		entries = parsed
+	} else {
+		if err := json.NewDecoder(c.Request().Body).Decode(&entries); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+		}
+	}
+	for i := range entries {
+		matchType, err := validateNGWord(entries[i].Word, entries[i].MatchType)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid NG word at index %d: %s", i, err.Error()))
+		}
+		entries[i].MatchType = matchType
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	ngwords, err := getAccountNGWords(ctx, tx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+	}
+	registered := make(map[NGWordListEntry]struct{}, len(ngwords))
+	for _, ngword := range ngwords {
+		registered[NGWordListEntry{Word: ngword.Word, MatchType: ngword.MatchType}] = struct{}{}
+	}
+
+	var res ImportNGWordsResponse
+	now := time.Now().Unix()
+	for _, entry := range entries {
+		if _, ok := registered[entry]; ok {
+			res.Skipped++
+			continue
+		}
+		rs, err := tx.NamedExecContext(ctx, "INSERT INTO ng_words(user_id, livestream_id, word, match_type, created_at) VALUES (:user_id, :livestream_id, :word, :match_type, :created_at)", &NGWord{
+			UserID:       userID,
+			LivestreamID: accountNGWordLivestreamID,
+			Word:         entry.Word,
+			MatchType:    entry.MatchType,
+			CreatedAt:    now,
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert new NG word: "+err.Error())
+		}
+		ngWordID, err := rs.LastInsertId()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted NG word id: "+err.Error())
+		}
+		if err := insertModerationLog(ctx, tx, &ModerationLogModel{
+			LivestreamID: accountNGWordLivestreamID,
+			UserID:       userID,
+			Action:       moderationActionAddNGWord,
+			NGWordID:     ngWordID,
+			CreatedAt:    now,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert moderation log: "+err.Error())
+		}
+		registered[entry] = struct{}{}
+		res.Imported++
+	}
+
+	var moderated map[int64][]int64
+	if res.Imported > 0 {
+		moderated, err = moderateAllLivecomments(ctx, tx, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old livecomments that hit spams: "+err.Error())
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+	ngWordMatchers.InvalidateByUserID(userID)
+
+	// 複数のNGワードをまとめて登録したので、NGワードIDは通知しない
+	for livestreamID, livecommentIDs := range moderated {
+		refreshRankingsAfterCommit(c, livestreamID)
+		publishModeratedLivecomments(livestreamID, 0, livecommentIDs)
+	}
+
+	return c.JSON(http.StatusCreated, res)
+}
+
+func getAccountNGWords(ctx context.Context, tx *sqlx.Tx, userID int64) ([]*NGWord, error) {
+	ngwords := []*NGWord{}
+	if err := tx.SelectContext(ctx, &ngwords, "SELECT * FROM ng_words WHERE user_id = ? AND livestream_id = ? ORDER BY created_at DESC, id DESC", userID, accountNGWordLivestreamID); err != nil {
+		return nil, err
+	}
+	fillNGWordScopes(ngwords)
+	return ngwords, nil
+}
+
+// parseNGWordListCSV は、word,match_type の2列からなるCSVを読み込む
+// 先頭行がヘッダの場合は読み飛ばし、match_type列は省略できる
+func parseNGWordListCSV(r io.Reader) ([]NGWordListEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && len(records[0]) > 0 && records[0][0] == "word" {
+		records = records[1:]
+	}
+
+	entries := make([]NGWordListEntry, 0, len(records))
+	for i, record := range records {
+		var entry NGWordListEntry
+		switch len(record) {
+		case 2:
+			entry.MatchType = record[1]
+			fallthrough
+		case 1:
+			entry.Word = record[0]
+		default:
+			return nil, fmt.Errorf("record %d must have 1 or 2 fields", i+1)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// ngWordMatchTypeSubstring は、正規化した上で部分一致するかを判定する
+	ngWordMatchTypeSubstring = "substring"
+	// ngWordMatchTypeGlob は、* と ? を含むパターンがコメントのどこかに一致するかを判定する
+	ngWordMatchTypeGlob = "glob"
+	// ngWordMatchTypeRegex は、正規表現がコメントのどこかに一致するかを判定する
+	ngWordMatchTypeRegex = "regex"
+)
+
+func isValidNGWordMatchType(matchType string) bool {
+	switch matchType {
+	case ngWordMatchTypeSubstring, ngWordMatchTypeGlob, ngWordMatchTypeRegex:
+		return true
+	default:
+		return false
+	}
+}
+
+// normalizeForNGWordMatch は、表記揺れによるすり抜けを防ぐため、NGワード判定用に文字列を正規化する
+//   - NFKCにより全角英数字・半角カナなどを統一する
+//   - カタカナをひらがなに寄せる
+//   - 空白文字・ゼロ幅文字を取り除く
+//   - foldCaseがtrueの場合、英字を小文字に寄せる
+func normalizeForNGWordMatch(s string, foldCase bool) string {
+	s = norm.NFKC.String(s)
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r), isZeroWidth(r):
+			continue
+		case r >= 'ァ' && r <= 'ヶ':
+			// 'ヵ', 'ヶ' もひらがなの 'ゕ', 'ゖ' に対応する
+			r -= 'ァ' - 'ぁ'
+		case foldCase:
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isZeroWidth(r rune) bool {
+	switch r {
+	case '\u200b', '\u200c', '\u200d', '\u2060', '\ufeff', '\u00ad':
+		return true
+	default:
+		return false
+	}
+}
+
+// ahoCorasick は、複数の文字列パターンのいずれかが含まれるかを一度の走査で判定するオートマトン
+type ahoCorasick struct {
+	nodes []ahoCorasickNode
+}
+
+type ahoCorasickNode struct {
+	next map[rune]int
+	fail int
+	// output は、このノードで一致するパターンの添字。一致しない場合は-1
+	output int
+}
+
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	ac := &ahoCorasick{
+		nodes: []ahoCorasickNode{{next: map[rune]int{}, output: -1}},
+	}
+
+	for i, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		cur := 0
+		for _, r := range pattern {
+			next, ok := ac.nodes[cur].next[r]
+			if !ok {
+				next = len(ac.nodes)
+				ac.nodes = append(ac.nodes, ahoCorasickNode{next: map[rune]int{}, output: -1})
+				ac.nodes[cur].next[r] = next
+			}
+			cur = next
+		}
+		if ac.nodes[cur].output == -1 {
+			ac.nodes[cur].output = i
+		}
+	}
+
+	// 幅優先で失敗遷移を構築する
+	queue := make([]int, 0, len(ac.nodes))
+	for _, child := range ac.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for r, child := range ac.nodes[cur].next {
+			fail := ac.nodes[cur].fail
+			for {
+				if next, ok := ac.nodes[fail].next[r]; ok && next != child {
+					ac.nodes[child].fail = next
+					break
+				}
+				if fail == 0 {
+					ac.nodes[child].fail = 0
+					break
+				}
+				fail = ac.nodes[fail].fail
+			}
+			// 接尾辞として含まれるパターンも一致として扱う
+			if ac.nodes[child].output == -1 {
+				ac.nodes[child].output = ac.nodes[ac.nodes[child].fail].output
+			}
+			queue = append(queue, child)
+		}
+	}
+
+	return ac
+}
+
+// FindFirst は、textに含まれるパターンのうち最初に見つかったものの添字を返す
+func (ac *ahoCorasick) FindFirst(text string) (int, bool) {
+	cur := 0
+	for _, r := range text {
+		for {
+			if next, ok := ac.nodes[cur].next[r]; ok {
+				cur = next
+				break
+			}
+			if cur == 0 {
+				break
+			}
+			cur = ac.nodes[cur].fail
+		}
+		if output := ac.nodes[cur].output; output >= 0 {
+			return output, true
+		}
+	}
+	return -1, false
+}
+
+// ngWordMatcher は、ライブ配信に登録されたNGワード群に対するコメントの判定器
+type ngWordMatcher struct {
+	substringWords []*NGWord
+	automaton      *ahoCorasick
+	patternWords   []*NGWord
+	patterns       []*regexp.Regexp
+}
+
+func newNGWordMatcher(ngwords []*NGWord) (*ngWordMatcher, error) {
+	m := &ngWordMatcher{}
+
+	var substrings []string
+	for _, ngword := range ngwords {
+		switch ngword.MatchType {
+		case ngWordMatchTypeGlob, ngWordMatchTypeRegex:
+			pattern, err := compileNGWordPattern(ngword.Word, ngword.MatchType)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compile NG word pattern (id=%d): %w", ngword.ID, err)
+			}
+			m.patternWords = append(m.patternWords, ngword)
+			m.patterns = append(m.patterns, pattern)
+		default:
+			word := normalizeForNGWordMatch(ngword.Word, true)
+			if word == "" {
+				continue
+			}
+			m.substringWords = append(m.substringWords, ngword)
+			substrings = append(substrings, word)
+		}
+	}
+	m.automaton = newAhoCorasick(substrings)
+
+	return m, nil
+}
+
+func compileNGWordPattern(word string, matchType string) (*regexp.Regexp, error) {
+	switch matchType {
+	case ngWordMatchTypeGlob:
+		word = normalizeForNGWordMatch(word, true)
+		var b strings.Builder
+		for _, r := range word {
+			switch r {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		return regexp.Compile(b.String())
+	case ngWordMatchTypeRegex:
+		// \S などのエスケープが変わらないよう、大文字小文字は正規表現側で無視する
+		return regexp.Compile("(?i)" + normalizeForNGWordMatch(word, false))
+	default:
+		return nil, fmt.Errorf("unknown match type: %s", matchType)
+	}
+}
+
+// Match は、コメントがいずれかのNGワードに一致する場合、一致したNGワードを返す
+func (m *ngWordMatcher) Match(comment string) (*NGWord, bool) {
+	text := normalizeForNGWordMatch(comment, true)
+
+	if i, ok := m.automaton.FindFirst(text); ok {
+		return m.substringWords[i], true
+	}
+	for i, pattern := range m.patterns {
+		if pattern.MatchString(text) {
+			return m.patternWords[i], true
+		}
+	}
+	return nil, false
+}
+
+// ngWordMatcherCache は、ライブ配信ごとのNGワード判定器のキャッシュ
+// NGワードが変更されたらInvalidateする
+type ngWordMatcherCache struct {
+	mu      sync.Mutex
+	entries map[int64]*ngWordMatcherCacheEntry
+	// generation は、Invalidateのたびに増える。構築中にInvalidateされた判定器をキャッシュしないために用いる
+	generation uint64
+	// load は、NGワードを読み込む
+	// 呼び出し元のトランザクションのスナップショットは古い可能性があるので、常に最新の状態を読むこと
+	load func(ctx context.Context, livestreamModel *LivestreamModel) ([]*NGWord, error)
+}
+
+type ngWordMatcherCacheEntry struct {
+	ownerID int64
+	matcher *ngWordMatcher
+}
+
+var ngWordMatchers = newNGWordMatcherCache()
+
+func newNGWordMatcherCache() *ngWordMatcherCache {
+	return &ngWordMatcherCache{
+		entries: make(map[int64]*ngWordMatcherCacheEntry),
+		load: func(ctx context.Context, livestreamModel *LivestreamModel) ([]*NGWord, error) {
+			return getEffectiveNGWords(ctx, dbConn, livestreamModel)
+		},
+	}
+}
+
+// Get は、ライブ配信の判定器を返す
+// NGワードは呼び出し元のトランザクションとは別に読み込む
+// (トランザクション開始後にコミットされたNGワードの変更を、古いスナップショットから読んでキャッシュしないため)
+func (c *ngWordMatcherCache) Get(ctx context.Context, livestreamModel *LivestreamModel) (*ngWordMatcher, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[livestreamModel.ID]; ok {
+		c.mu.Unlock()
+		return entry.matcher, nil
+	}
+	// NGワードを読み込む前に世代を読むこと
+	// NGワードの変更はコミット後にInvalidateされるので、読み込み中に変更された場合は世代が変わる
+	generation := c.generation
+	c.mu.Unlock()
+
+	ngwords, err := c.load(ctx, livestreamModel)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newNGWordMatcher(ngwords)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.entries[livestreamModel.ID] = &ngWordMatcherCacheEntry{
+			ownerID: livestreamModel.UserID,
+			matcher: m,
+		}
+	}
+	return m, nil
+}
+
+func (c *ngWordMatcherCache) Invalidate(livestreamID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, livestreamID)
+	c.generation++
+}
+
+// InvalidateByUserID は、配信者のアカウント単位のNGワードが変更された際に、その配信者の全ての配信の判定器を破棄する
+func (c *ngWordMatcherCache) InvalidateByUserID(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for livestreamID, entry := range c.entries {
+		if entry.ownerID == userID {
+			delete(c.entries, livestreamID)
+		}
+	}
+	c.generation++
+}
+
+func (c *ngWordMatcherCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[int64]*ngWordMatcherCacheEntry)
+	c.generation++
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// fakeNGWordStore は、NGワードの読み込みを差し替えるためのテスト用ストア
+type fakeNGWordStore struct {
+	mu    sync.Mutex
+	words []*NGWord
+	loads int
+	// loadingが設定されていれば、読み込み後にloadingへ通知し、resumeを待つ
+	loading chan struct{}
+	resume  chan struct{}
+}
+
+func (s *fakeNGWordStore) load(ctx context.Context, livestreamModel *LivestreamModel) ([]*NGWord, error) {
+	s.mu.Lock()
+	words := append([]*NGWord(nil), s.words...)
+	s.loads++
+	loading, resume := s.loading, s.resume
+	s.mu.Unlock()
+
+	if loading != nil {
+		loading <- struct{}{}
+		<-resume
+	}
+	return words, nil
+}
+
+func (s *fakeNGWordStore) add(word string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.words = append(s.words, &NGWord{ID: int64(len(s.words) + 1), Word: word, MatchType: ngWordMatchTypeSubstring})
+}
+
+func newTestNGWordMatcherCache(store *fakeNGWordStore) *ngWordMatcherCache {
+	c := newNGWordMatcherCache()
+	c.load = store.load
+	return c
+}
+
+func TestNGWordMatcherCacheGet(t *testing.T) {
+	store := &fakeNGWordStore{}
+	store.add("spam")
+	cache := newTestNGWordMatcherCache(store)
+	livestream := &LivestreamModel{ID: 1, UserID: 1}
+
+	for i := 0; i < 2; i++ {
+		m, err := cache.Get(context.Background(), livestream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, hit := m.Match("this is spam"); !hit {
+			t.Fatalf("expected comment to hit NG word")
+		}
+	}
+	if store.loads != 1 {
+		t.Fatalf("expected NG words to be loaded once, got %d", store.loads)
+	}
+
+	store.add("scam")
+	cache.Invalidate(livestream.ID)
+	m, err := cache.Get(context.Background(), livestream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, hit := m.Match("this is scam"); !hit {
+		t.Fatalf("expected NG word added before Invalidate to be enforced")
+	}
+}
+
+// NGワードの読み込み中にNGワードが追加されてInvalidateされた場合、古い判定器をキャッシュしないこと
+func TestNGWordMatcherCacheGetRacesWithModerate(t *testing.T) {
+	resume := make(chan struct{})
+	store := &fakeNGWordStore{
+		loading: make(chan struct{}),
+		resume:  resume,
+	}
+	store.add("spam")
+	cache := newTestNGWordMatcherCache(store)
+	livestream := &LivestreamModel{ID: 1, UserID: 1}
+
+	done := make(chan *ngWordMatcher)
+	go func() {
+		m, err := cache.Get(context.Background(), livestream)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- m
+	}()
+
+	// Getが古いNGワードを読み込んだ後に、moderateがコミットしてInvalidateする
+	<-store.loading
+	store.add("scam")
+	cache.Invalidate(livestream.ID)
+
+	store.mu.Lock()
+	store.loading, store.resume = nil, nil
+	store.mu.Unlock()
+	// 待っているGetを再開させる
+	close(resume)
+	<-done
+
+	m, err := cache.Get(context.Background(), livestream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, hit := m.Match("this is scam"); !hit {
+		t.Fatalf("stale matcher was cached: NG word added by moderate is not enforced")
+	}
+}
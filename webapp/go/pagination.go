@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PageCursor は、一覧APIのページングに用いるカーソル
+// クライアントには不透明な文字列として渡す
+type PageCursor struct {
+	ID        int64 `json:"id"`
+	CreatedAt int64 `json:"created_at,omitempty"`
+}
+
+func (cur PageCursor) Encode() string {
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageCursor(s string) (*PageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cur PageCursor
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// PageQuery は、limit, before, after クエリパラメータから組み立てたページング条件
+// Beforeは指定カーソルより古いもの、Afterは指定カーソルより新しいものを返す
+type PageQuery struct {
+	Limit  int
+	Before *PageCursor
+	After  *PageCursor
+}
+
+func parsePageQuery(c echo.Context) (*PageQuery, error) {
+	q := &PageQuery{}
+
+	if c.QueryParam("limit") != "" {
+		limit, err := strconv.Atoi(c.QueryParam("limit"))
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
+		}
+		q.Limit = limit
+	}
+
+	if c.QueryParam("before") != "" && c.QueryParam("after") != "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "before and after query parameters can't be specified at the same time")
+	}
+	if v := c.QueryParam("before"); v != "" {
+		cur, err := decodePageCursor(v)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid before cursor")
+		}
+		q.Before = cur
+	}
+	if v := c.QueryParam("after"); v != "" {
+		cur, err := decodePageCursor(v)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid after cursor")
+		}
+		q.After = cur
+	}
+
+	return q, nil
+}
+
+// TimelineCondition は、(created_at, id) をキーにしたカーソル条件を返す
+// カーソルが指定されていない場合は空文字を返す
+func (q *PageQuery) TimelineCondition() (string, []interface{}) {
+	switch {
+	case q.Before != nil:
+		return "(created_at < ? OR (created_at = ? AND id < ?))", []interface{}{q.Before.CreatedAt, q.Before.CreatedAt, q.Before.ID}
+	case q.After != nil:
+		return "(created_at > ? OR (created_at = ? AND id > ?))", []interface{}{q.After.CreatedAt, q.After.CreatedAt, q.After.ID}
+	default:
+		return "", nil
+	}
+}
+
+// IDCondition は、指定カラムをキーにしたカーソル条件を返す
+// カーソルが指定されていない場合は空文字を返す
+func (q *PageQuery) IDCondition(column string) (string, []interface{}) {
+	switch {
+	case q.Before != nil:
+		return column + " < ?", []interface{}{q.Before.ID}
+	case q.After != nil:
+		return column + " > ?", []interface{}{q.After.ID}
+	default:
+		return "", nil
+	}
+}
+
+// Order は、SQLでの並び順を返す
+// afterの場合はカーソルに近いものから取得する必要があるので昇順で取得し、取得後に反転する
+func (q *PageQuery) Order() string {
+	if q.After != nil {
+		return "ASC"
+	}
+	return "DESC"
+}
+
+func (q *PageQuery) LimitClause() string {
+	if q.Limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d", q.Limit)
+}
+
+// setNextPageLink は、続きのページが存在しうる場合に rel="next" のLinkヘッダを付与する
+// newestとoldestは、降順に並べた取得結果の先頭と末尾のカーソル
+func setNextPageLink(c echo.Context, q *PageQuery, count int, newest, oldest PageCursor) {
+	if q.Limit <= 0 || count < q.Limit {
+		return
+	}
+
+	u := *c.Request().URL
+	query := u.Query()
+	if q.After != nil {
+		query.Del("before")
+		query.Set("after", newest.Encode())
+	} else {
+		query.Del("after")
+		query.Set("before", oldest.Encode())
+	}
+	u.RawQuery = query.Encode()
+
+	c.Response().Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+}
@@ -1,26 +1,203 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 )
 
+// 日別集計の基準となるタイムゾーン (JST)
+const paymentDayOffsetSeconds = 9 * 60 * 60
+
+var paymentLocation = time.FixedZone("Asia/Tokyo", paymentDayOffsetSeconds)
+
+// TipModel は、チップの台帳
+// ライブコメントが削除・非表示にされても、支払いの記録として残る
+type TipModel struct {
+	ID            int64 `db:"id"`
+	LivecommentID int64 `db:"livecomment_id"`
+	LivestreamID  int64 `db:"livestream_id"`
+	// チップを支払ったユーザ
+	UserID int64 `db:"user_id"`
+	// チップを受け取った配信者
+	StreamerID int64 `db:"streamer_id"`
+	Amount     int64 `db:"amount"`
+	CreatedAt  int64 `db:"created_at"`
+}
+
 type PaymentResult struct {
 	TotalTip int64 `json:"total_tip"`
 }
 
+type StreamerPaymentResult struct {
+	TotalTip    int64                       `json:"total_tip"`
+	TipCount    int64                       `json:"tip_count"`
+	Livestreams []*LivestreamPaymentSummary `json:"livestreams"`
+	Daily       []*DailyPaymentSummary      `json:"daily"`
+}
+
+type LivestreamPaymentSummary struct {
+	LivestreamID int64  `json:"livestream_id" db:"livestream_id"`
+	Title        string `json:"title" db:"title"`
+	TotalTip     int64  `json:"total_tip" db:"total_tip"`
+	TipCount     int64  `json:"tip_count" db:"tip_count"`
+}
+
+type DailyPaymentSummary struct {
+	// YYYY-MM-DD (JST)
+	Date     string `json:"date"`
+	TotalTip int64  `json:"total_tip"`
+	TipCount int64  `json:"tip_count"`
+}
+
+// tipPayoutsTable は、返金・保留を差し引いたチップを、受け取ったユーザごとの行に分けたもの
+// コラボレーターにはチップを受け取った時点の分配率で切り捨てた額を、配信者には残りを支払う
+// 列は t.tip_id, t.livestream_id, t.recipient_id, t.amount, t.created_at
+const tipPayoutsTable = "(" +
+	"SELECT t.id AS tip_id, t.livestream_id, t.streamer_id AS recipient_id, t.created_at," +
+	" t.amount - IFNULL(r.amount, 0) - IFNULL((SELECT SUM(FLOOR((t.amount - IFNULL(r.amount, 0)) * s.share_percent / 100)) FROM tip_shares s WHERE s.tip_id = t.id), 0) AS amount" +
+	" FROM " + tipsWithRefundsJoin +
+	" UNION ALL " +
+	"SELECT t.id AS tip_id, t.livestream_id, s.user_id AS recipient_id, t.created_at," +
+	" FLOOR((t.amount - IFNULL(r.amount, 0)) * s.share_percent / 100) AS amount" +
+	" FROM " + tipsWithRefundsJoin + " INNER JOIN tip_shares s ON s.tip_id = t.id" +
+	") t"
+
+// paymentFilter は、チップの集計対象を絞り込む条件
+// tipPayoutsTableに対して用いる
+type paymentFilter struct {
+	// チップを受け取ったユーザ (0の場合は指定なし)
+	RecipientID int64
+	// from <= created_at < to (0の場合は指定なし)
+	From int64
+	To   int64
+}
+
+func (f *paymentFilter) Condition() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.RecipientID != 0 {
+		conds = append(conds, "t.recipient_id = ?")
+		args = append(args, f.RecipientID)
+	}
+	if f.From != 0 {
+		conds = append(conds, "t.created_at >= ?")
+		args = append(args, f.From)
+	}
+	if f.To != 0 {
+		conds = append(conds, "t.created_at < ?")
+		args = append(args, f.To)
+	}
+	if len(conds) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// parsePaymentTime は、UNIXタイムスタンプ(秒)またはYYYY-MM-DD(JSTの0時)を解釈する
+func parsePaymentTime(name, v string) (int64, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if n <= 0 {
+			return 0, echo.NewHTTPError(http.StatusBadRequest, name+" must be positive")
+		}
+		return n, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, paymentLocation)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, name+" must be unix timestamp or YYYY-MM-DD")
+	}
+	return t.Unix(), nil
+}
+
+// parsePaymentPeriod は、クエリパラメータのfrom/toを解釈する
+// toは含まない (from=2023-11-01&to=2023-12-01 で11月分)
+func parsePaymentPeriod(c echo.Context, filter *paymentFilter) error {
+	if v := c.QueryParam("from"); v != "" {
+		from, err := parsePaymentTime("from", v)
+		if err != nil {
+			return err
+		}
+		filter.From = from
+	}
+	if v := c.QueryParam("to"); v != "" {
+		to, err := parsePaymentTime("to", v)
+		if err != nil {
+			return err
+		}
+		filter.To = to
+	}
+	if filter.From != 0 && filter.To != 0 && filter.From >= filter.To {
+		return echo.NewHTTPError(http.StatusBadRequest, "from must be before to")
+	}
+	return nil
+}
+
+// insertTip は、ライブコメントに付与されたチップを台帳に記録する
+// ライブコメントの投稿と同じトランザクションで呼び出すこと
+func insertTip(ctx context.Context, tx *sqlx.Tx, livestreamModel *LivestreamModel, livecommentModel *LivecommentModel) error {
+	if livecommentModel.Tip <= 0 {
+		return nil
+	}
+
+	tipModel := TipModel{
+		LivecommentID: livecommentModel.ID,
+		LivestreamID:  livestreamModel.ID,
+		UserID:        livecommentModel.UserID,
+		StreamerID:    livestreamModel.UserID,
+		Amount:        livecommentModel.Tip,
+		CreatedAt:     livecommentModel.CreatedAt,
+	}
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO tips (livecomment_id, livestream_id, user_id, streamer_id, amount, created_at) VALUES (:livecomment_id, :livestream_id, :user_id, :streamer_id, :amount, :created_at)", tipModel)
+	if err != nil {
+		return err
+	}
+	tipID, err := rs.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	// 後から分配率が変更されても、受け取った時点の分配率で支払う
+	_, err = tx.ExecContext(ctx, "INSERT INTO tip_shares (tip_id, user_id, share_percent) SELECT ?, user_id, tip_share_percent FROM livestream_collaborators WHERE livestream_id = ? AND status = ? AND tip_share_percent > 0", tipID, livestreamModel.ID, collaboratorStatusAccepted)
+	return err
+}
+
+// 売上取得API
+// GET /api/payment?from=&to=&streamer=
+// 返金・保留中のチップは差し引く。streamerを指定した場合は、コラボレーターとしての取り分を含めた、そのユーザの受け取り額を返す
 func GetPaymentResult(c echo.Context) error {
 	ctx := c.Request().Context()
 
+	var filter paymentFilter
+	if err := parsePaymentPeriod(c, &filter); err != nil {
+		return err
+	}
+
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
 	}
 	defer tx.Rollback()
 
+	if streamerName := c.QueryParam("streamer"); streamerName != "" {
+		if err := tx.GetContext(ctx, &filter.RecipientID, "SELECT id FROM users WHERE name = ?", streamerName); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusNotFound, "not found streamer that has the given name")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get streamer: "+err.Error())
+		}
+	}
+
+	cond, args := filter.Condition()
 	var totalTip int64
-	if err := tx.GetContext(ctx, &totalTip, "SELECT IFNULL(SUM(tip), 0) FROM livecomments"); err != nil {
+	if err := tx.GetContext(ctx, &totalTip, "SELECT IFNULL(SUM(t.amount), 0) FROM "+tipPayoutsTable+" WHERE "+cond, args...); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to count total tip: "+err.Error())
 	}
 
@@ -32,3 +209,74 @@ func GetPaymentResult(c echo.Context) error {
 		TotalTip: totalTip,
 	})
 }
+
+// 配信者向け売上取得API (配信ごと・日ごとの内訳)
+// GET /api/payment/me?from=&to=
+// 返金・保留中のチップは差し引く。コラボレーターへの分配は、配信者とコラボレーターのそれぞれの受け取り額に反映する
+func getMyPaymentHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	filter := paymentFilter{RecipientID: userID}
+	if err := parsePaymentPeriod(c, &filter); err != nil {
+		return err
+	}
+	cond, args := filter.Condition()
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	// 配信が削除されていても、支払いの記録は残す
+	livestreams := []*LivestreamPaymentSummary{}
+	query := "SELECT t.livestream_id, IFNULL(l.title, '') AS title, SUM(t.amount) AS total_tip, COUNT(*) AS tip_count" +
+		" FROM " + tipPayoutsTable + " LEFT JOIN livestreams l ON l.id = t.livestream_id" +
+		" WHERE " + cond +
+		" GROUP BY t.livestream_id, l.title ORDER BY t.livestream_id"
+	if err := tx.SelectContext(ctx, &livestreams, query, args...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get tips per livestream: "+err.Error())
+	}
+
+	var days []struct {
+		Day      int64 `db:"day"`
+		TotalTip int64 `db:"total_tip"`
+		TipCount int64 `db:"tip_count"`
+	}
+	query = "SELECT FLOOR((t.created_at + ?) / 86400) AS day, SUM(t.amount) AS total_tip, COUNT(*) AS tip_count" +
+		" FROM " + tipPayoutsTable + " WHERE " + cond +
+		" GROUP BY day ORDER BY day"
+	if err := tx.SelectContext(ctx, &days, query, append([]interface{}{paymentDayOffsetSeconds}, args...)...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get tips per day: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	result := StreamerPaymentResult{
+		Livestreams: livestreams,
+		Daily:       make([]*DailyPaymentSummary, len(days)),
+	}
+	for i, d := range days {
+		result.TotalTip += d.TotalTip
+		result.TipCount += d.TipCount
+		result.Daily[i] = &DailyPaymentSummary{
+			Date:     time.Unix(d.Day*86400, 0).UTC().Format("2006-01-02"),
+			TotalTip: d.TotalTip,
+			TipCount: d.TipCount,
+		}
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// 最後のハートビートからこの時間が経過した視聴者は、視聴を終えたものとみなす
+	presenceTTL = 30 * time.Second
+	// クライアントに案内するハートビートの間隔
+	presenceHeartbeatInterval = 10 * time.Second
+	// 同時視聴者数をDBに書き出す間隔
+	presenceFlushInterval = 5 * time.Second
+)
+
+// LivestreamPresenceModel は、DBに書き出した同時視聴者数
+type LivestreamPresenceModel struct {
+	LivestreamID   int64 `db:"livestream_id"`
+	CurrentViewers int64 `db:"current_viewers"`
+	PeakViewers    int64 `db:"peak_viewers"`
+	PeakAt         int64 `db:"peak_at"`
+	UpdatedAt      int64 `db:"updated_at"`
+}
+
+type livestreamPresence struct {
+	// ユーザIDごとの有効期限
+	viewers     map[int64]time.Time
+	peakViewers int64
+	peakAt      int64
+	// DBに書き出した最大同時視聴者数を反映済みか
+	peakLoaded bool
+}
+
+// viewerPresenceTracker は、ハートビートを元にライブ配信ごとの同時視聴者を管理する
+type viewerPresenceTracker struct {
+	mu          sync.Mutex
+	livestreams map[int64]*livestreamPresence
+	// 前回の書き出し以降に変化があったライブ配信
+	dirty map[int64]struct{}
+}
+
+var viewerPresence = newViewerPresenceTracker()
+
+func newViewerPresenceTracker() *viewerPresenceTracker {
+	return &viewerPresenceTracker{
+		livestreams: make(map[int64]*livestreamPresence),
+		dirty:       make(map[int64]struct{}),
+	}
+}
+
+func (t *viewerPresenceTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.livestreams = make(map[int64]*livestreamPresence)
+	t.dirty = make(map[int64]struct{})
+}
+
+func (t *viewerPresenceTracker) get(livestreamID int64) *livestreamPresence {
+	p, ok := t.livestreams[livestreamID]
+	if !ok {
+		p = &livestreamPresence{viewers: make(map[int64]time.Time)}
+		t.livestreams[livestreamID] = p
+	}
+	return p
+}
+
+// expire は、有効期限が切れた視聴者を取り除く
+func (t *viewerPresenceTracker) expire(livestreamID int64, p *livestreamPresence, now time.Time) {
+	for userID, expiresAt := range p.viewers {
+		if !now.Before(expiresAt) {
+			delete(p.viewers, userID)
+			t.dirty[livestreamID] = struct{}{}
+		}
+	}
+}
+
+// Touch は、視聴者の有効期限を延長し、現在の同時視聴者数を返す
+// 2つ目の返り値がfalseの場合、最大同時視聴者数はDBの値を反映していない
+func (t *viewerPresenceTracker) Touch(livestreamID, userID int64, now time.Time) (LivestreamPresence, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := t.get(livestreamID)
+	t.expire(livestreamID, p, now)
+	p.viewers[userID] = now.Add(presenceTTL)
+	if current := int64(len(p.viewers)); current > p.peakViewers {
+		p.peakViewers = current
+		p.peakAt = now.Unix()
+	}
+	t.dirty[livestreamID] = struct{}{}
+	return t.snapshot(livestreamID, p), p.peakLoaded
+}
+
+// Leave は、視聴者を取り除く
+func (t *viewerPresenceTracker) Leave(livestreamID, userID int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p, ok := t.livestreams[livestreamID]; ok {
+		delete(p.viewers, userID)
+		t.dirty[livestreamID] = struct{}{}
+	}
+}
+
+// Remove は、削除されたライブ配信の情報を取り除く
+func (t *viewerPresenceTracker) Remove(livestreamID int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.livestreams, livestreamID)
+	delete(t.dirty, livestreamID)
+}
+
+// Current は、現在の同時視聴者数と最大同時視聴者数を返す
+// 2つ目の返り値がfalseの場合、最大同時視聴者数はDBの値を反映していない
+func (t *viewerPresenceTracker) Current(livestreamID int64, now time.Time) (LivestreamPresence, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.livestreams[livestreamID]
+	if !ok {
+		return LivestreamPresence{LivestreamID: livestreamID}, false
+	}
+	t.expire(livestreamID, p, now)
+	return t.snapshot(livestreamID, p), p.peakLoaded
+}
+
+// restorePeak は、DBに書き出した最大同時視聴者数を反映する
+func (t *viewerPresenceTracker) restorePeak(livestreamID, peakViewers, peakAt int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.livestreams[livestreamID]
+	if !ok {
+		return
+	}
+	if peakViewers > p.peakViewers {
+		p.peakViewers = peakViewers
+		p.peakAt = peakAt
+	}
+	p.peakLoaded = true
+}
+
+// removeIdle は、書き出し後に変化がなく、視聴者もいないライブ配信の情報を取り除く
+// 最大同時視聴者数はDBに書き出し済みなので、次に必要になった際に読み込み直す
+func (t *viewerPresenceTracker) removeIdle(livestreamIDs []int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, livestreamID := range livestreamIDs {
+		if _, ok := t.dirty[livestreamID]; ok {
+			continue
+		}
+		if p, ok := t.livestreams[livestreamID]; ok && len(p.viewers) == 0 {
+			delete(t.livestreams, livestreamID)
+		}
+	}
+}
+
+func (t *viewerPresenceTracker) snapshot(livestreamID int64, p *livestreamPresence) LivestreamPresence {
+	return LivestreamPresence{
+		LivestreamID:   livestreamID,
+		CurrentViewers: int64(len(p.viewers)),
+		PeakViewers:    p.peakViewers,
+		PeakAt:         p.peakAt,
+	}
+}
+
+// currentViewerPresence は、Touch・Currentで得た同時視聴者数に、必要であればDBに書き出した最大同時視聴者数を反映する
+// 視聴中の視聴者は、再起動後のハートビートで改めて数える
+func currentViewerPresence(ctx context.Context, db *sqlx.DB, presence LivestreamPresence, peakLoaded bool) (LivestreamPresence, error) {
+	if peakLoaded {
+		return presence, nil
+	}
+
+	var model LivestreamPresenceModel
+	if err := db.GetContext(ctx, &model, "SELECT * FROM livestream_presence WHERE livestream_id = ?", presence.LivestreamID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return presence, err
+	}
+	viewerPresence.restorePeak(presence.LivestreamID, model.PeakViewers, model.PeakAt)
+	if model.PeakViewers > presence.PeakViewers {
+		presence.PeakViewers = model.PeakViewers
+		presence.PeakAt = model.PeakAt
+	}
+	return presence, nil
+}
+
+// flushViewerPresence は、前回の書き出し以降に変化があったライブ配信の同時視聴者数を書き出す
+func flushViewerPresence(ctx context.Context, db *sqlx.DB, now time.Time) error {
+	viewerPresence.mu.Lock()
+	models := make([]*LivestreamPresenceModel, 0, len(viewerPresence.dirty))
+	for livestreamID := range viewerPresence.dirty {
+		p := viewerPresence.livestreams[livestreamID]
+		viewerPresence.expire(livestreamID, p, now)
+		models = append(models, &LivestreamPresenceModel{
+			LivestreamID:   livestreamID,
+			CurrentViewers: int64(len(p.viewers)),
+			PeakViewers:    p.peakViewers,
+			PeakAt:         p.peakAt,
+			UpdatedAt:      now.Unix(),
+		})
+	}
+	viewerPresence.dirty = make(map[int64]struct{})
+	viewerPresence.mu.Unlock()
+
+	livestreamIDs := make([]int64, 0, len(models))
+	for _, m := range models {
+		// peak_atはpeak_viewersを更新する前に比較する必要がある
+		if _, err := db.NamedExecContext(ctx, "INSERT INTO livestream_presence (livestream_id, current_viewers, peak_viewers, peak_at, updated_at) VALUES (:livestream_id, :current_viewers, :peak_viewers, :peak_at, :updated_at)"+
+			" ON DUPLICATE KEY UPDATE current_viewers = VALUES(current_viewers), peak_at = IF(VALUES(peak_viewers) > peak_viewers, VALUES(peak_at), peak_at), peak_viewers = GREATEST(peak_viewers, VALUES(peak_viewers)), updated_at = VALUES(updated_at)", m); err != nil {
+			return err
+		}
+		livestreamIDs = append(livestreamIDs, m.LivestreamID)
+	}
+
+	// 終了したライブ配信の情報が残り続けないよう、書き出し後に取り除く
+	viewerPresence.removeIdle(livestreamIDs)
+	return nil
+}
+
+// runViewerPresenceFlusher は、定期的に同時視聴者数をDBに書き出す
+func runViewerPresenceFlusher(ctx context.Context, db *sqlx.DB, logger echo.Logger) {
+	ticker := time.NewTicker(presenceFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := flushViewerPresence(ctx, db, now); err != nil {
+				logger.Warnf("failed to flush viewer presence: %s", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 視聴者がいなくなったライブ配信の情報が残り続けないこと
+func TestViewerPresenceRemoveIdle(t *testing.T) {
+	tracker := newViewerPresenceTracker()
+	now := time.Unix(1700000000, 0)
+
+	tracker.Touch(1, 10, now)
+	tracker.Touch(1, 11, now)
+	tracker.Touch(2, 10, now)
+
+	// 書き出し前に変化があったものは取り除かない
+	tracker.Leave(1, 10)
+	tracker.Leave(1, 11)
+	tracker.removeIdle([]int64{1, 2})
+	if _, ok := tracker.livestreams[1]; !ok {
+		t.Fatalf("livestream with unflushed changes was removed")
+	}
+
+	tracker.dirty = make(map[int64]struct{})
+	tracker.removeIdle([]int64{1, 2})
+	if _, ok := tracker.livestreams[1]; ok {
+		t.Fatalf("livestream without viewers was not removed")
+	}
+	if _, ok := tracker.livestreams[2]; !ok {
+		t.Fatalf("livestream with viewers was removed")
+	}
+
+	// 取り除いた後は、最大同時視聴者数をDBから読み込む必要がある
+	if presence, peakLoaded := tracker.Current(1, now); peakLoaded || presence.PeakViewers != 0 {
+		t.Fatalf("Current() = %+v, %v, want zero value, false", presence, peakLoaded)
+	}
+	tracker.Touch(1, 10, now)
+	tracker.restorePeak(1, 2, now.Unix())
+	if presence, peakLoaded := tracker.Current(1, now); !peakLoaded || presence.PeakViewers != 2 || presence.CurrentViewers != 1 {
+		t.Fatalf("Current() = %+v, %v, want peak 2, current 1, true", presence, peakLoaded)
+	}
+}
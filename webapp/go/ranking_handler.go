@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	rankingPeriodDaily  = "daily"
+	rankingPeriodWeekly = "weekly"
+	rankingPeriodAll    = "all"
+
+	rankingDefaultLimit = 10
+	rankingMaxLimit     = 100
+)
+
+type UserRankingResponseEntry struct {
+	Rank      int64 `json:"rank"`
+	User      User  `json:"user"`
+	Score     int64 `json:"score"`
+	Reactions int64 `json:"reactions"`
+	Tips      int64 `json:"tips"`
+}
+
+type LivestreamRankingResponseEntry struct {
+	Rank       int64      `json:"rank"`
+	Livestream Livestream `json:"livestream"`
+	Score      int64      `json:"score"`
+	Reactions  int64      `json:"reactions"`
+	Tips       int64      `json:"tips"`
+}
+
+// parseRankingPeriod は、periodクエリパラメータから集計開始時刻を返す
+// 直近24時間(daily)、直近7日間(weekly)、全期間(all)のいずれかで、全期間の場合は0を返す
+func parseRankingPeriod(c echo.Context) (int64, error) {
+	switch c.QueryParam("period") {
+	case "", rankingPeriodAll:
+		return 0, nil
+	case rankingPeriodDaily:
+		return time.Now().Add(-24 * time.Hour).Unix(), nil
+	case rankingPeriodWeekly:
+		return time.Now().Add(-7 * 24 * time.Hour).Unix(), nil
+	default:
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "period must be one of daily, weekly or all")
+	}
+}
+
+// parseRankingPage は、limit, offsetクエリパラメータを解釈する
+func parseRankingPage(c echo.Context) (limit int, offset int, err error) {
+	limit = rankingDefaultLimit
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > rankingMaxLimit {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit query parameter must be between 1 and %d", rankingMaxLimit))
+		}
+	}
+	if v := c.QueryParam("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "offset query parameter must be non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
+
+// setNextRankingPageLink は、続きの順位が存在する場合に rel="next" のLinkヘッダを付与する
+func setNextRankingPageLink(c echo.Context, limit, offset, total int) {
+	if offset+limit >= total {
+		return
+	}
+
+	u := *c.Request().URL
+	query := u.Query()
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("offset", strconv.Itoa(offset+limit))
+	u.RawQuery = query.Encode()
+
+	c.Response().Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+}
+
+// computeUserRanking は、全ユーザ(配信者)のランキングを順位の高い順に返す
+// スコアは、配信に対するリアクション数と、返金・保留中のものを除いたチップの合計
+// sinceが0でない場合は、since以降のリアクション・チップのみを集計する
+func computeUserRanking(ctx context.Context, tx *sqlx.Tx, since int64) (UserRanking, error) {
+	var ranking UserRanking
+	if err := tx.SelectContext(ctx, &ranking, "SELECT id AS user_id, name AS username FROM users"); err != nil {
+		return nil, err
+	}
+
+	var reactions []struct {
+		UserID int64 `db:"user_id"`
+		Count  int64 `db:"count"`
+	}
+	if err := tx.SelectContext(ctx, &reactions, "SELECT l.user_id, COUNT(*) AS count FROM reactions r INNER JOIN livestreams l ON l.id = r.livestream_id WHERE r.created_at >= ? GROUP BY l.user_id", since); err != nil {
+		return nil, err
+	}
+	var tips []struct {
+		UserID int64 `db:"user_id"`
+		Total  int64 `db:"total"`
+	}
+	if err := tx.SelectContext(ctx, &tips, "SELECT t.streamer_id AS user_id, SUM(t.amount - IFNULL(r.amount, 0)) AS total FROM "+tipsWithRefundsJoin+" WHERE t.created_at >= ? GROUP BY t.streamer_id", since); err != nil {
+		return nil, err
+	}
+
+	index := make(map[int64]int, len(ranking))
+	for i := range ranking {
+		index[ranking[i].UserID] = i
+	}
+	for _, r := range reactions {
+		if i, ok := index[r.UserID]; ok {
+			ranking[i].Reactions = r.Count
+		}
+	}
+	for _, t := range tips {
+		if i, ok := index[t.UserID]; ok {
+			ranking[i].Tips = t.Total
+		}
+	}
+	for i := range ranking {
+		ranking[i].Score = ranking[i].Reactions + ranking[i].Tips
+	}
+
+	sort.Sort(sort.Reverse(ranking))
+	return ranking, nil
+}
+
+// computeLivestreamRanking は、全ライブ配信のランキングを順位の高い順に返す
+// スコアは、リアクション数と、返金・保留中のものを除いたチップの合計
+// sinceが0でない場合は、since以降のリアクション・チップのみを集計する
+func computeLivestreamRanking(ctx context.Context, tx *sqlx.Tx, since int64) (LivestreamRanking, error) {
+	var ranking LivestreamRanking
+	if err := tx.SelectContext(ctx, &ranking, "SELECT id AS livestream_id FROM livestreams"); err != nil {
+		return nil, err
+	}
+
+	var reactions []struct {
+		LivestreamID int64 `db:"livestream_id"`
+		Count        int64 `db:"count"`
+	}
+	if err := tx.SelectContext(ctx, &reactions, "SELECT livestream_id, COUNT(*) AS count FROM reactions WHERE created_at >= ? GROUP BY livestream_id", since); err != nil {
+		return nil, err
+	}
+	var tips []struct {
+		LivestreamID int64 `db:"livestream_id"`
+		Total        int64 `db:"total"`
+	}
+	if err := tx.SelectContext(ctx, &tips, "SELECT t.livestream_id, SUM(t.amount - IFNULL(r.amount, 0)) AS total FROM "+tipsWithRefundsJoin+" WHERE t.created_at >= ? GROUP BY t.livestream_id", since); err != nil {
+		return nil, err
+	}
+
+	index := make(map[int64]int, len(ranking))
+	for i := range ranking {
+		index[ranking[i].LivestreamID] = i
+	}
+	for _, r := range reactions {
+		if i, ok := index[r.LivestreamID]; ok {
+			ranking[i].Reactions = r.Count
+		}
+	}
+	for _, t := range tips {
+		if i, ok := index[t.LivestreamID]; ok {
+			ranking[i].Tips = t.Total
+		}
+	}
+	for i := range ranking {
+		ranking[i].Score = ranking[i].Reactions + ranking[i].Tips
+	}
+
+	sort.Sort(sort.Reverse(ranking))
+	return ranking, nil
+}
+
+// getUserRankingPage は、offset番目から最大limit件の配信者ランキングと、ランキング全体の件数を返す
+// 全期間の場合は統計情報カウンタから構築済みのランキングを使い、それ以外は期間内のリアクション・チップを集計する
+func getUserRankingPage(ctx context.Context, tx *sqlx.Tx, since int64, limit, offset int) (UserRanking, int, error) {
+	if since != 0 {
+		ranking, err := computeUserRanking(ctx, tx, since)
+		if err != nil {
+			return nil, 0, err
+		}
+		if offset >= len(ranking) {
+			return UserRanking{}, len(ranking), nil
+		}
+		return ranking[offset:min(offset+limit, len(ranking))], len(ranking), nil
+	}
+
+	total := userRanking.Len()
+	keys := userRanking.Range(offset, limit)
+	ranking := make(UserRanking, len(keys))
+	for i, key := range keys {
+		var stats UserStatisticsModel
+		if err := tx.GetContext(ctx, &stats, "SELECT * FROM user_statistics WHERE user_id = ?", key.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, err
+		}
+		ranking[i] = UserRankingEntry{
+			UserID:    key.ID,
+			Username:  key.Name,
+			Score:     key.Score,
+			Reactions: stats.TotalReactions,
+			Tips:      stats.TotalTip,
+		}
+	}
+	return ranking, total, nil
+}
+
+// getLivestreamRankingPage は、offset番目から最大limit件のライブ配信ランキングと、ランキング全体の件数を返す
+func getLivestreamRankingPage(ctx context.Context, tx *sqlx.Tx, since int64, limit, offset int) (LivestreamRanking, int, error) {
+	if since != 0 {
+		ranking, err := computeLivestreamRanking(ctx, tx, since)
+		if err != nil {
+			return nil, 0, err
+		}
+		if offset >= len(ranking) {
+			return LivestreamRanking{}, len(ranking), nil
+		}
+		return ranking[offset:min(offset+limit, len(ranking))], len(ranking), nil
+	}
+
+	total := livestreamRanking.Len()
+	keys := livestreamRanking.Range(offset, limit)
+	ranking := make(LivestreamRanking, len(keys))
+	for i, key := range keys {
+		var stats LivestreamStatisticsModel
+		if err := tx.GetContext(ctx, &stats, "SELECT * FROM livestream_statistics WHERE livestream_id = ?", key.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, err
+		}
+		ranking[i] = LivestreamRankingEntry{
+			LivestreamID: key.ID,
+			Score:        key.Score,
+			Reactions:    stats.TotalReactions,
+			Tips:         stats.NetTip,
+		}
+	}
+	return ranking, total, nil
+}
+
+// 配信者ランキング取得API
+// GET /api/ranking/users?period=&limit=&offset=
+func getUserRankingHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	since, err := parseRankingPeriod(c)
+	if err != nil {
+		return err
+	}
+	limit, offset, err := parseRankingPage(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	ranking, total, err := getUserRankingPage(ctx, tx, since, limit, offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user ranking: "+err.Error())
+	}
+
+	entries := []UserRankingResponseEntry{}
+	for i := range ranking {
+		userModel := UserModel{}
+		if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ?", ranking[i].UserID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+		user, err := fillUserResponse(ctx, tx, userModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+		}
+		entries = append(entries, UserRankingResponseEntry{
+			Rank:      int64(offset + i + 1),
+			User:      user,
+			Score:     ranking[i].Score,
+			Reactions: ranking[i].Reactions,
+			Tips:      ranking[i].Tips,
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	setNextRankingPageLink(c, limit, offset, total)
+	return c.JSON(http.StatusOK, entries)
+}
+
+// ライブ配信ランキング取得API
+// GET /api/ranking/livestreams?period=&limit=&offset=
+func getLivestreamRankingHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	since, err := parseRankingPeriod(c)
+	if err != nil {
+		return err
+	}
+	limit, offset, err := parseRankingPage(c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	ranking, total, err := getLivestreamRankingPage(ctx, tx, since, limit, offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream ranking: "+err.Error())
+	}
+
+	entries := []LivestreamRankingResponseEntry{}
+	for i := range ranking {
+		livestreamModel := LivestreamModel{}
+		if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", ranking[i].LivestreamID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+		livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+		}
+		entries = append(entries, LivestreamRankingResponseEntry{
+			Rank:       int64(offset + i + 1),
+			Livestream: livestream,
+			Score:      ranking[i].Score,
+			Reactions:  ranking[i].Reactions,
+			Tips:       ranking[i].Tips,
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	setNextRankingPageLink(c, limit, offset, total)
+	return c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	rateLimitPerSecondEnvKey = "ISUCON13_RATE_LIMIT_PER_SECOND"
+	rateLimitBurstEnvKey     = "ISUCON13_RATE_LIMIT_BURST"
+
+	// ユーザごとに1秒あたりに補充するトークン数
+	rateLimitDefaultPerSecond = 10.0
+	// ユーザごとのバケットの容量
+	rateLimitDefaultBurst = 20
+
+	// バケット数がこれを超えたら、満タンになったバケットを掃除する
+	rateLimitSweepThreshold = 10000
+)
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// tokenBucketLimiter は、キーごとのトークンバケットでリクエスト数を制限する
+// perSecondが0以下の場合は制限しない
+type tokenBucketLimiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+}
+
+// livecommentRateLimiter は、ライブコメント投稿・リアクション投稿に対するユーザごとのレートリミット
+var livecommentRateLimiter *tokenBucketLimiter
+
+func init() {
+	perSecond := rateLimitDefaultPerSecond
+	if v, ok := os.LookupEnv(rateLimitPerSecondEnvKey); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			log.Fatalf("environment variable '%s' must be non-negative number", rateLimitPerSecondEnvKey)
+		}
+		perSecond = f
+	}
+	burst := rateLimitDefaultBurst
+	if v, ok := os.LookupEnv(rateLimitBurstEnvKey); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("environment variable '%s' must be positive integer", rateLimitBurstEnvKey)
+		}
+		burst = n
+	}
+	livecommentRateLimiter = newTokenBucketLimiter(perSecond, burst)
+}
+
+func newTokenBucketLimiter(perSecond float64, burst int) *tokenBucketLimiter {
+	return &tokenBucketLimiter{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// Allow は、トークンを1つ消費できればtrueを返す
+// 消費できない場合は、次にトークンが補充されるまでの時間を返す
+func (l *tokenBucketLimiter) Allow(now time.Time, key string) (time.Duration, bool) {
+	if l.perSecond <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= rateLimitSweepThreshold {
+			l.sweep(now)
+		}
+		b = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.perSecond)
+	b.updated = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// sweep は、満タンまで補充されたバケットを削除する
+func (l *tokenBucketLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.perSecond >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *tokenBucketLimiter) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buckets = make(map[string]*tokenBucket)
+}
+
+// checkRateLimit は、ユーザごとにレートリミットを課す
+// 制限を超えた場合は、429とRetry-Afterヘッダを返す
+// Idempotency-Keyによる再送はトークンを消費させないため、再送の判定後に呼び出すこと
+func checkRateLimit(c echo.Context, limiter *tokenBucketLimiter, userID int64) error {
+	if retryAfter, ok := limiter.Allow(time.Now(), "user:"+strconv.FormatInt(userID, 10)); !ok {
+		return newTooManyRequestsError(c, retryAfter, "too many requests")
+	}
+	return nil
+}
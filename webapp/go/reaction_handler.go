@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -52,19 +55,30 @@ func getReactionsHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
-	query := "SELECT * FROM reactions WHERE livestream_id = ? ORDER BY created_at DESC"
-	if c.QueryParam("limit") != "" {
-		limit, err := strconv.Atoi(c.QueryParam("limit"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
-		}
-		query += fmt.Sprintf(" LIMIT %d", limit)
+	page, err := parsePageQuery(c)
+	if err != nil {
+		return err
 	}
 
+	query := "SELECT * FROM reactions WHERE livestream_id = ?"
+	args := []interface{}{livestreamID}
+	if cond, condArgs := page.TimelineCondition(); cond != "" {
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	query += fmt.Sprintf(" ORDER BY created_at %[1]s, id %[1]s", page.Order())
+	query += page.LimitClause()
+
 	reactionModels := []ReactionModel{}
-	if err := tx.SelectContext(ctx, &reactionModels, query, livestreamID); err != nil {
+	if err := tx.SelectContext(ctx, &reactionModels, query, args...); err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "failed to get reactions")
 	}
+	if page.After != nil {
+		// 新しい順に揃える
+		for i, j := 0, len(reactionModels)-1; i < j; i, j = i+1, j-1 {
+			reactionModels[i], reactionModels[j] = reactionModels[j], reactionModels[i]
+		}
+	}
 
 	reactions := make([]Reaction, len(reactionModels))
 	for i := range reactionModels {
@@ -80,6 +94,13 @@ func getReactionsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
 
+	if n := len(reactionModels); n > 0 {
+		newest, oldest := reactionModels[0], reactionModels[n-1]
+		setNextPageLink(c, page, n,
+			PageCursor{ID: newest.ID, CreatedAt: newest.CreatedAt},
+			PageCursor{ID: oldest.ID, CreatedAt: oldest.CreatedAt})
+	}
+
 	return c.JSON(http.StatusOK, reactions)
 }
 
@@ -100,8 +121,13 @@ func postReactionHandler(c echo.Context) error {
 	// existence already checked
 	userID := sess.Values[defaultUserIDKey].(int64)
 
+	// Idempotency-Keyの照合のため、リクエストボディを保持しておく
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to read the request body")
+	}
 	var req *PostReactionRequest
-	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
@@ -111,6 +137,29 @@ func postReactionHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
+	// 同じIdempotency-Keyでのリトライであれば、最初のレスポンスをそのまま返す
+	idempotencyKey, replay, err := beginIdempotentRequest(ctx, tx, c, userID, body)
+	if err != nil {
+		return err
+	}
+	if replay != nil {
+		return replayIdempotentResponse(c, replay)
+	}
+	if err := checkRateLimit(c, livecommentRateLimiter, userID); err != nil {
+		return err
+	}
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+	if err := verifyUserNotBanned(ctx, tx, c, livestreamModel.UserID, userID); err != nil {
+		return err
+	}
+
 	reactionModel := ReactionModel{
 		UserID:       int64(userID),
 		LivestreamID: int64(livestreamID),
@@ -128,15 +177,23 @@ func postReactionHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted reaction id: "+err.Error())
 	}
 	reactionModel.ID = reactionID
+	if err := addStatistics(ctx, tx, reactionModel.LivestreamID, statisticsDelta{TotalReactions: 1}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update statistics: "+err.Error())
+	}
 
 	reaction, err := fillReactionResponse(ctx, tx, reactionModel)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill reaction: "+err.Error())
 	}
 
+	if err := completeIdempotentRequest(ctx, tx, idempotencyKey, http.StatusCreated, reaction); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save idempotent response: "+err.Error())
+	}
+
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
+	refreshRankingsAfterCommit(c, reactionModel.LivestreamID)
 
 	return c.JSON(http.StatusCreated, reaction)
 }
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// refundPolicyKeep は、moderationされたライブコメントのチップも配信者の売上とする (デフォルト)
+	refundPolicyKeep = "keep"
+	// refundPolicyRefund は、moderationされたライブコメントのチップを視聴者に返金する
+	refundPolicyRefund = "refund"
+	// refundPolicyEscrow は、moderationされたライブコメントのチップを保留し、配信者が後で返金か売上かを決める
+	refundPolicyEscrow = "escrow"
+
+	refundStatusRefunded = "refunded"
+	refundStatusEscrowed = "escrowed"
+	// refundStatusReleased は、保留されていたチップが配信者の売上に戻されたことを表す
+	refundStatusReleased = "released"
+
+	// tipsWithRefundsJoin は、チップに対して売上から差し引く返金・保留を結合する
+	// t.amount - IFNULL(r.amount, 0) が配信者の売上となる
+	tipsWithRefundsJoin = "tips t LEFT JOIN refunds r ON r.tip_id = t.id AND r.status IN ('" + refundStatusRefunded + "', '" + refundStatusEscrowed + "')"
+)
+
+func isValidRefundPolicy(policy string) bool {
+	switch policy {
+	case refundPolicyKeep, refundPolicyRefund, refundPolicyEscrow:
+		return true
+	default:
+		return false
+	}
+}
+
+type PaymentSettingsModel struct {
+	UserID       int64  `db:"user_id"`
+	RefundPolicy string `db:"refund_policy"`
+}
+
+type PaymentSettings struct {
+	// RefundPolicy は keep, refund, escrow のいずれか
+	RefundPolicy string `json:"refund_policy"`
+}
+
+type RefundModel struct {
+	ID            int64  `db:"id"`
+	TipID         int64  `db:"tip_id"`
+	LivecommentID int64  `db:"livecomment_id"`
+	LivestreamID  int64  `db:"livestream_id"`
+	UserID        int64  `db:"user_id"`
+	StreamerID    int64  `db:"streamer_id"`
+	Amount        int64  `db:"amount"`
+	Status        string `db:"status"`
+	CreatedAt     int64  `db:"created_at"`
+	ResolvedAt    int64  `db:"resolved_at"`
+}
+
+type Refund struct {
+	ID            int64  `json:"id"`
+	TipID         int64  `json:"tip_id"`
+	LivecommentID int64  `json:"livecomment_id"`
+	LivestreamID  int64  `json:"livestream_id"`
+	Amount        int64  `json:"amount"`
+	Status        string `json:"status"`
+	CreatedAt     int64  `json:"created_at"`
+	ResolvedAt    int64  `json:"resolved_at,omitempty"`
+	// User は、チップを支払った視聴者
+	User User `json:"user"`
+}
+
+// TipHistory は、視聴者が支払ったチップと、その返金状況
+type TipHistory struct {
+	ID            int64 `json:"id"`
+	LivecommentID int64 `json:"livecomment_id"`
+	LivestreamID  int64 `json:"livestream_id"`
+	// Streamer は、チップを受け取った配信者
+	Streamer  User  `json:"streamer"`
+	Amount    int64 `json:"amount"`
+	CreatedAt int64 `json:"created_at"`
+	// Refund は、返金・保留されている場合のみ設定する
+	Refund *Refund `json:"refund,omitempty"`
+}
+
+type ResolveRefundRequest struct {
+	// Status は refunded か released のいずれか
+	Status string `json:"status"`
+}
+
+func getRefundPolicy(ctx context.Context, tx *sqlx.Tx, streamerID int64) (string, error) {
+	var policy string
+	if err := tx.GetContext(ctx, &policy, "SELECT refund_policy FROM payment_settings WHERE user_id = ?", streamerID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return refundPolicyKeep, nil
+		}
+		return "", err
+	}
+	return policy, nil
+}
+
+// applyRefundPolicy は、moderationにより非表示にされたライブコメントのチップを、配信者の返金ポリシーに従って処理する
+// 新たに返金・保留して配信者の売上から差し引いた額を返す
+func applyRefundPolicy(ctx context.Context, tx *sqlx.Tx, livecommentModel *LivecommentModel) (int64, error) {
+	if livecommentModel.Tip <= 0 {
+		return 0, nil
+	}
+
+	var tipModel TipModel
+	if err := tx.GetContext(ctx, &tipModel, "SELECT * FROM tips WHERE livecomment_id = ?", livecommentModel.ID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	policy, err := getRefundPolicy(ctx, tx, tipModel.StreamerID)
+	if err != nil {
+		return 0, err
+	}
+	var status string
+	switch policy {
+	case refundPolicyRefund:
+		status = refundStatusRefunded
+	case refundPolicyEscrow:
+		status = refundStatusEscrowed
+	default:
+		return 0, nil
+	}
+
+	now := time.Now().Unix()
+	var refundModel RefundModel
+	if err := tx.GetContext(ctx, &refundModel, "SELECT * FROM refunds WHERE tip_id = ? FOR UPDATE", tipModel.ID); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+		refundModel = RefundModel{
+			TipID:         tipModel.ID,
+			LivecommentID: tipModel.LivecommentID,
+			LivestreamID:  tipModel.LivestreamID,
+			UserID:        tipModel.UserID,
+			StreamerID:    tipModel.StreamerID,
+			Amount:        tipModel.Amount,
+			Status:        status,
+			CreatedAt:     now,
+		}
+		if status == refundStatusRefunded {
+			refundModel.ResolvedAt = now
+		}
+		if _, err := tx.NamedExecContext(ctx, "INSERT INTO refunds (tip_id, livecomment_id, livestream_id, user_id, streamer_id, amount, status, created_at, resolved_at) VALUES (:tip_id, :livecomment_id, :livestream_id, :user_id, :streamer_id, :amount, :status, :created_at, :resolved_at)", refundModel); err != nil {
+			return 0, err
+		}
+		return refundModel.Amount, nil
+	}
+
+	// 返金済み・保留中のものはそのまま
+	if refundModel.Status != refundStatusReleased {
+		return 0, nil
+	}
+	resolvedAt := int64(0)
+	if status == refundStatusRefunded {
+		resolvedAt = now
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE refunds SET status = ?, created_at = ?, resolved_at = ? WHERE id = ?", status, now, resolvedAt, refundModel.ID); err != nil {
+		return 0, err
+	}
+	return refundModel.Amount, nil
+}
+
+// releaseEscrowedRefund は、復元されたライブコメントのチップの保留を解除し、配信者の売上に戻す
+// 既に返金されたものは取り消さない。売上に戻した額を返す
+func releaseEscrowedRefund(ctx context.Context, tx *sqlx.Tx, livecommentID int64) (int64, error) {
+	var refundModel RefundModel
+	if err := tx.GetContext(ctx, &refundModel, "SELECT * FROM refunds WHERE livecomment_id = ? AND status = ? FOR UPDATE", livecommentID, refundStatusEscrowed); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE refunds SET status = ?, resolved_at = ? WHERE id = ?", refundStatusReleased, time.Now().Unix(), refundModel.ID); err != nil {
+		return 0, err
+	}
+	return refundModel.Amount, nil
+}
+
+func fillRefundResponse(ctx context.Context, tx *sqlx.Tx, refundModel *RefundModel) (Refund, error) {
+	userModel := UserModel{}
+	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ?", refundModel.UserID); err != nil {
+		return Refund{}, err
+	}
+	user, err := fillUserResponse(ctx, tx, userModel)
+	if err != nil {
+		return Refund{}, err
+	}
+
+	return Refund{
+		ID:            refundModel.ID,
+		TipID:         refundModel.TipID,
+		LivecommentID: refundModel.LivecommentID,
+		LivestreamID:  refundModel.LivestreamID,
+		Amount:        refundModel.Amount,
+		Status:        refundModel.Status,
+		CreatedAt:     refundModel.CreatedAt,
+		ResolvedAt:    refundModel.ResolvedAt,
+		User:          user,
+	}, nil
+}
+
+// 返金ポリシー取得API
+// GET /api/payment/me/settings
+func getPaymentSettingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	policy, err := getRefundPolicy(ctx, tx, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get refund policy: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, PaymentSettings{RefundPolicy: policy})
+}
+
+// 返金ポリシー更新API
+// PUT /api/payment/me/settings
+// 変更後にmoderationされたライブコメントにのみ適用する
+func putPaymentSettingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *PaymentSettings
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if !isValidRefundPolicy(req.RefundPolicy) {
+		return echo.NewHTTPError(http.StatusBadRequest, "refund_policy must be one of keep, refund or escrow")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	settingsModel := PaymentSettingsModel{
+		UserID:       userID,
+		RefundPolicy: req.RefundPolicy,
+	}
+	if _, err := tx.NamedExecContext(ctx, "INSERT INTO payment_settings (user_id, refund_policy) VALUES (:user_id, :refund_policy) ON DUPLICATE KEY UPDATE refund_policy = VALUES(refund_policy)", settingsModel); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save payment settings: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, PaymentSettings{RefundPolicy: settingsModel.RefundPolicy})
+}
+
+// 配信者向け返金一覧取得API
+// GET /api/payment/me/refunds?status=
+func getStreamerRefundsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	page, err := parsePageQuery(c)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT * FROM refunds WHERE streamer_id = ?"
+	args := []interface{}{userID}
+	if status := c.QueryParam("status"); status != "" {
+		switch status {
+		case refundStatusRefunded, refundStatusEscrowed, refundStatusReleased:
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "status must be one of refunded, escrowed or released")
+		}
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	if cond, condArgs := page.IDCondition("id"); cond != "" {
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	query += " ORDER BY id " + page.Order() + page.LimitClause()
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var refundModels []*RefundModel
+	if err := tx.SelectContext(ctx, &refundModels, query, args...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get refunds: "+err.Error())
+	}
+	if page.After != nil {
+		// 新しい順に揃える
+		for i, j := 0, len(refundModels)-1; i < j; i, j = i+1, j-1 {
+			refundModels[i], refundModels[j] = refundModels[j], refundModels[i]
+		}
+	}
+
+	refunds := make([]Refund, len(refundModels))
+	for i := range refundModels {
+		refund, err := fillRefundResponse(ctx, tx, refundModels[i])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill refund: "+err.Error())
+		}
+		refunds[i] = refund
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	if len(refunds) > 0 {
+		setNextPageLink(c, page, len(refunds), PageCursor{ID: refunds[0].ID}, PageCursor{ID: refunds[len(refunds)-1].ID})
+	}
+
+	return c.JSON(http.StatusOK, refunds)
+}
+
+// 保留中のチップの返金・売上確定API
+// POST /api/payment/me/refunds/:refund_id/resolve
+func resolveRefundHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	refundID, err := strconv.Atoi(c.Param("refund_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "refund_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *ResolveRefundRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.Status != refundStatusRefunded && req.Status != refundStatusReleased {
+		return echo.NewHTTPError(http.StatusBadRequest, "status must be refunded or released")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var refundModel RefundModel
+	if err := tx.GetContext(ctx, &refundModel, "SELECT * FROM refunds WHERE id = ? AND streamer_id = ? FOR UPDATE", refundID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "refund not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get refund: "+err.Error())
+	}
+	if refundModel.Status != refundStatusEscrowed {
+		return echo.NewHTTPError(http.StatusBadRequest, "refund is not escrowed")
+	}
+
+	refundModel.Status = req.Status
+	refundModel.ResolvedAt = time.Now().Unix()
+	if _, err := tx.NamedExecContext(ctx, "UPDATE refunds SET status = :status, resolved_at = :resolved_at WHERE id = :id", refundModel); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to resolve refund: "+err.Error())
+	}
+	if refundModel.Status == refundStatusReleased {
+		if err := addStatistics(ctx, tx, refundModel.LivestreamID, statisticsDelta{NetTip: refundModel.Amount}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update statistics: "+err.Error())
+		}
+	}
+
+	refund, err := fillRefundResponse(ctx, tx, &refundModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill refund: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+	if refundModel.Status == refundStatusReleased {
+		refreshRankingsAfterCommit(c, refundModel.LivestreamID)
+	}
+
+	return c.JSON(http.StatusOK, refund)
+}
+
+// 視聴者向けチップ・返金履歴取得API
+// GET /api/user/me/tips
+func getMyTipsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	page, err := parsePageQuery(c)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT * FROM tips WHERE user_id = ?"
+	args := []interface{}{userID}
+	if cond, condArgs := page.IDCondition("id"); cond != "" {
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	query += " ORDER BY id " + page.Order() + page.LimitClause()
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var tipModels []*TipModel
+	if err := tx.SelectContext(ctx, &tipModels, query, args...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get tips: "+err.Error())
+	}
+	if page.After != nil {
+		// 新しい順に揃える
+		for i, j := 0, len(tipModels)-1; i < j; i, j = i+1, j-1 {
+			tipModels[i], tipModels[j] = tipModels[j], tipModels[i]
+		}
+	}
+
+	tips := make([]TipHistory, len(tipModels))
+	for i, tipModel := range tipModels {
+		streamerModel := UserModel{}
+		if err := tx.GetContext(ctx, &streamerModel, "SELECT * FROM users WHERE id = ?", tipModel.StreamerID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get streamer: "+err.Error())
+		}
+		streamer, err := fillUserResponse(ctx, tx, streamerModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill streamer: "+err.Error())
+		}
+
+		tips[i] = TipHistory{
+			ID:            tipModel.ID,
+			LivecommentID: tipModel.LivecommentID,
+			LivestreamID:  tipModel.LivestreamID,
+			Streamer:      streamer,
+			Amount:        tipModel.Amount,
+			CreatedAt:     tipModel.CreatedAt,
+		}
+
+		var refundModel RefundModel
+		if err := tx.GetContext(ctx, &refundModel, "SELECT * FROM refunds WHERE tip_id = ?", tipModel.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get refund: "+err.Error())
+		}
+		refund, err := fillRefundResponse(ctx, tx, &refundModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill refund: "+err.Error())
+		}
+		tips[i].Refund = &refund
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	if len(tips) > 0 {
+		setNextPageLink(c, page, len(tips), PageCursor{ID: tips[0].ID}, PageCursor{ID: tips[len(tips)-1].ID})
+	}
+
+	return c.JSON(http.StatusOK, tips)
+}
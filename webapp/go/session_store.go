@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	sessionStoreEnvKey = "ISUCON13_SESSION_STORE"
+
+	sessionStoreTypeMySQL  = "mysql"
+	sessionStoreTypeMemory = "memory"
+)
+
+var errSessionNotFound = errors.New("session not found")
+
+// SessionModel は、サーバ側で保持するセッション
+// IDはログイン時に発行し、cookieのSESSIONIDに格納しているuuid
+type SessionModel struct {
+	ID        string `db:"id"`
+	UserID    int64  `db:"user_id"`
+	ExpiresAt int64  `db:"expires_at"`
+	CreatedAt int64  `db:"created_at"`
+}
+
+// SessionStore は、セッションの保存先
+// cookieが漏洩した場合でも、ここから削除することでセッションを無効化できる
+type SessionStore interface {
+	Create(ctx context.Context, sess *SessionModel) error
+	// Get は、セッションが存在しない場合にerrSessionNotFoundを返す
+	Get(ctx context.Context, id string) (*SessionModel, error)
+	Delete(ctx context.Context, id string) error
+	// DeleteByUserID は、ユーザの全てのセッションを削除する
+	DeleteByUserID(ctx context.Context, userID int64) error
+	// Reset は、全てのセッションを削除する (初期化時に呼び出す)
+	Reset(ctx context.Context) error
+}
+
+var sessionStore SessionStore
+
+func newSessionStore(storeType string, db *sqlx.DB) (SessionStore, error) {
+	switch storeType {
+	case "", sessionStoreTypeMySQL:
+		return &mysqlSessionStore{db: db}, nil
+	case sessionStoreTypeMemory:
+		return newMemorySessionStore(), nil
+	default:
+		return nil, errors.New("unknown session store type: " + storeType)
+	}
+}
+
+type mysqlSessionStore struct {
+	db *sqlx.DB
+}
+
+func (s *mysqlSessionStore) Create(ctx context.Context, sess *SessionModel) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT INTO sessions (id, user_id, expires_at, created_at) VALUES (:id, :user_id, :expires_at, :created_at)", sess)
+	return err
+}
+
+func (s *mysqlSessionStore) Get(ctx context.Context, id string) (*SessionModel, error) {
+	var sess SessionModel
+	if err := s.db.GetContext(ctx, &sess, "SELECT * FROM sessions WHERE id = ?", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errSessionNotFound
+		}
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s *mysqlSessionStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
+
+func (s *mysqlSessionStore) DeleteByUserID(ctx context.Context, userID int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// Reset は何もしない
+// sessionsテーブルは、初期化時にinit.sqlでTRUNCATEしている
+func (s *mysqlSessionStore) Reset(ctx context.Context) error {
+	return nil
+}
+
+// memorySessionStore は、プロセス内にセッションを保持する
+// 複数台構成ではセッションが共有されないので注意
+type memorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*SessionModel
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{
+		sessions: make(map[string]*SessionModel),
+	}
+}
+
+func (s *memorySessionStore) Create(ctx context.Context, sess *SessionModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *sess
+	s.sessions[sess.ID] = &copied
+	return nil
+}
+
+func (s *memorySessionStore) Get(ctx context.Context, id string) (*SessionModel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil, errSessionNotFound
+	}
+	copied := *sess
+	return &copied, nil
+}
+
+func (s *memorySessionStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *memorySessionStore) DeleteByUserID(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, sess := range s.sessions {
+		if sess.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *memorySessionStore) Reset(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions = make(map[string]*SessionModel)
+	return nil
+}
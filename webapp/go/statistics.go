@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// LivestreamStatisticsModel は、書き込み時に更新するライブ配信ごとの統計情報
+type LivestreamStatisticsModel struct {
+	LivestreamID int64 `db:"livestream_id"`
+	UserID       int64 `db:"user_id"`
+	// ViewersCount は、入室時に加算し退室時に減算する視聴履歴の件数
+	// 退室しないまま離脱したクライアントも含まれるが、ベンチマーカーは入退室の回数と照合するため、
+	// ハートビートを元にした同時視聴者数 (viewerPresence) とは別の指標として扱う
+	ViewersCount int64 `db:"viewers_count"`
+	// UniqueViewers は、一度でも視聴したことのあるユーザ数
+	UniqueViewers int64 `db:"unique_viewers"`
+	// TotalReactions は、リアクション数
+	TotalReactions int64 `db:"total_reactions"`
+	// TotalLivecomments は、非表示にされたものを除いたライブコメント数
+	TotalLivecomments int64 `db:"total_livecomments"`
+	// TotalTip は、非表示にされたものも含めたチップの合計
+	TotalTip int64 `db:"total_tip"`
+	// NetTip は、返金・保留中のものを除いたチップの合計
+	NetTip int64 `db:"net_tip"`
+	MaxTip int64 `db:"max_tip"`
+	// TotalReports は、未対応のスパム報告数
+	TotalReports int64 `db:"total_reports"`
+}
+
+// UserStatisticsModel は、書き込み時に更新する配信者ごとの統計情報
+// 配信者の全てのライブ配信の合計
+type UserStatisticsModel struct {
+	UserID            int64 `db:"user_id"`
+	ViewersCount      int64 `db:"viewers_count"`
+	TotalReactions    int64 `db:"total_reactions"`
+	TotalLivecomments int64 `db:"total_livecomments"`
+	// TotalTip は、返金・保留中のものを除いたチップの合計
+	// ライブ配信が削除されても、チップの台帳と同様に残す
+	TotalTip int64 `db:"total_tip"`
+}
+
+// statisticsDelta は、統計情報カウンタへの差分
+type statisticsDelta struct {
+	ViewersCount      int64
+	UniqueViewers     int64
+	TotalReactions    int64
+	TotalLivecomments int64
+	TotalTip          int64
+	NetTip            int64
+	TotalReports      int64
+	// MaxTip は、これより小さい場合のみ更新する
+	MaxTip int64
+}
+
+// addStatistics は、ライブ配信と、その配信者の統計情報カウンタに差分を加える
+// 書き込みと同じトランザクションで呼び出し、コミット後にrefreshRankingsを呼び出すこと
+func addStatistics(ctx context.Context, tx *sqlx.Tx, livestreamID int64, d statisticsDelta) error {
+	var streamerID int64
+	if err := tx.GetContext(ctx, &streamerID, "SELECT user_id FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// 削除されたライブ配信 (次のreconcileStatisticsで反映される)
+			return nil
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO livestream_statistics (livestream_id, user_id, viewers_count, unique_viewers, total_reactions, total_livecomments, total_tip, net_tip, max_tip, total_reports) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"+
+			" ON DUPLICATE KEY UPDATE viewers_count = viewers_count + VALUES(viewers_count), unique_viewers = unique_viewers + VALUES(unique_viewers), total_reactions = total_reactions + VALUES(total_reactions), total_livecomments = total_livecomments + VALUES(total_livecomments),"+
+			" total_tip = total_tip + VALUES(total_tip), net_tip = net_tip + VALUES(net_tip), max_tip = GREATEST(max_tip, VALUES(max_tip)), total_reports = total_reports + VALUES(total_reports)",
+		livestreamID, streamerID, d.ViewersCount, d.UniqueViewers, d.TotalReactions, d.TotalLivecomments, d.TotalTip, d.NetTip, d.MaxTip, d.TotalReports,
+	); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO user_statistics (user_id, viewers_count, total_reactions, total_livecomments, total_tip) VALUES (?, ?, ?, ?, ?)"+
+			" ON DUPLICATE KEY UPDATE viewers_count = viewers_count + VALUES(viewers_count), total_reactions = total_reactions + VALUES(total_reactions),"+
+			" total_livecomments = total_livecomments + VALUES(total_livecomments), total_tip = total_tip + VALUES(total_tip)",
+		streamerID, d.ViewersCount, d.TotalReactions, d.TotalLivecomments, d.NetTip,
+	)
+	return err
+}
+
+// deleteLivestreamStatistics は、削除するライブ配信の統計情報を配信者の統計情報から差し引く
+// チップは台帳に残るので差し引かない
+func deleteLivestreamStatistics(ctx context.Context, tx *sqlx.Tx, livestreamID int64) error {
+	var stats LivestreamStatisticsModel
+	if err := tx.GetContext(ctx, &stats, "SELECT * FROM livestream_statistics WHERE livestream_id = ? FOR UPDATE", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE user_statistics SET viewers_count = viewers_count - ?, total_reactions = total_reactions - ?, total_livecomments = total_livecomments - ? WHERE user_id = ?", stats.ViewersCount, stats.TotalReactions, stats.TotalLivecomments, stats.UserID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "DELETE FROM livestream_statistics WHERE livestream_id = ?", livestreamID)
+	return err
+}
+
+// reconcileStatistics は、統計情報カウンタを元のテーブルから再構築し、ランキングを読み込み直す
+func reconcileStatistics(ctx context.Context, db *sqlx.DB) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM livestream_statistics",
+		"DELETE FROM user_statistics",
+		"INSERT IGNORE INTO livestream_unique_viewers (livestream_id, user_id, created_at)" +
+			" SELECT livestream_id, user_id, MIN(entered_at) FROM livestream_viewer_sessions GROUP BY livestream_id, user_id",
+		"INSERT INTO livestream_statistics (livestream_id, user_id, viewers_count, unique_viewers, total_reactions, total_livecomments, total_tip, net_tip, max_tip, total_reports)" +
+			" SELECT l.id, l.user_id," +
+			" (SELECT COUNT(*) FROM livestream_viewers_history h WHERE h.livestream_id = l.id)," +
+			" (SELECT COUNT(*) FROM livestream_unique_viewers v WHERE v.livestream_id = l.id)," +
+			" (SELECT COUNT(*) FROM reactions r WHERE r.livestream_id = l.id)," +
+			" (SELECT COUNT(*) FROM livecomments c WHERE c.livestream_id = l.id AND c.hidden_at = 0)," +
+			" (SELECT IFNULL(SUM(c.tip), 0) FROM livecomments c WHERE c.livestream_id = l.id)," +
+			" (SELECT IFNULL(SUM(t.amount - IFNULL(r.amount, 0)), 0) FROM " + tipsWithRefundsJoin + " WHERE t.livestream_id = l.id)," +
+			" (SELECT IFNULL(MAX(c.tip), 0) FROM livecomments c WHERE c.livestream_id = l.id)," +
+			" (SELECT COUNT(*) FROM livecomment_reports p WHERE p.livestream_id = l.id AND p.status = '" + livecommentReportStatusOpen + "')" +
+			" FROM livestreams l",
+		"INSERT INTO user_statistics (user_id, viewers_count, total_reactions, total_livecomments, total_tip)" +
+			" SELECT u.id, IFNULL(SUM(s.viewers_count), 0), IFNULL(SUM(s.total_reactions), 0), IFNULL(SUM(s.total_livecomments), 0)," +
+			" (SELECT IFNULL(SUM(t.amount - IFNULL(r.amount, 0)), 0) FROM " + tipsWithRefundsJoin + " WHERE t.streamer_id = u.id)" +
+			" FROM users u LEFT JOIN livestream_statistics s ON s.user_id = u.id GROUP BY u.id",
+	} {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return loadRankings(ctx, db)
+}
+
+// loadRankings は、統計情報カウンタからランキングを構築する
+func loadRankings(ctx context.Context, db *sqlx.DB) error {
+	version := nextRankingVersion()
+
+	var users []struct {
+		UserID   int64  `db:"user_id"`
+		Username string `db:"username"`
+		Score    int64  `db:"score"`
+	}
+	if err := db.SelectContext(ctx, &users, "SELECT u.id AS user_id, u.name AS username, IFNULL(s.total_reactions + s.total_tip, 0) AS score FROM users u LEFT JOIN user_statistics s ON s.user_id = u.id"); err != nil {
+		return err
+	}
+	var livestreams []struct {
+		LivestreamID int64 `db:"livestream_id"`
+		Score        int64 `db:"score"`
+	}
+	if err := db.SelectContext(ctx, &livestreams, "SELECT l.id AS livestream_id, IFNULL(s.total_reactions + s.net_tip, 0) AS score FROM livestreams l LEFT JOIN livestream_statistics s ON s.livestream_id = l.id"); err != nil {
+		return err
+	}
+
+	userRanking.Reset()
+	for _, u := range users {
+		userRanking.Set(u.UserID, u.Username, u.Score, version)
+	}
+	livestreamRanking.Reset()
+	for _, l := range livestreams {
+		livestreamRanking.Set(l.LivestreamID, "", l.Score, version)
+	}
+	return nil
+}
+
+// rankingVersion は、ランキングを更新するために統計情報カウンタを読み込んだ順序
+// 読み込みはロックせずに並行して行うため、古い読み込み結果で新しい値を上書きしないよう、ランキング側で比較する
+var rankingVersion atomic.Uint64
+
+// nextRankingVersion は、統計情報カウンタを読み込む直前に呼び出す
+// 後から払い出されたバージョンの読み込みには、それ以前にコミットされた書き込みが全て含まれる
+func nextRankingVersion() uint64 {
+	return rankingVersion.Add(1)
+}
+
+// refreshRankings は、コミット済みの統計情報カウンタから、ライブ配信とその配信者のランキングを更新する
+func refreshRankings(ctx context.Context, livestreamIDs ...int64) error {
+	version := nextRankingVersion()
+
+	streamerIDs := make(map[int64]struct{})
+	for _, livestreamID := range livestreamIDs {
+		var stats LivestreamStatisticsModel
+		if err := dbConn.GetContext(ctx, &stats, "SELECT * FROM livestream_statistics WHERE livestream_id = ?", livestreamID); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			// 削除されたライブ配信
+			livestreamRanking.Remove(livestreamID, version)
+			continue
+		}
+		livestreamRanking.Set(livestreamID, "", stats.TotalReactions+stats.NetTip, version)
+		streamerIDs[stats.UserID] = struct{}{}
+	}
+
+	for streamerID := range streamerIDs {
+		if err := refreshUserRankingWithVersion(ctx, streamerID, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshRankingsAfterCommit は、コミット後にランキングを更新する
+// 失敗しても書き込み自体は完了しているので、警告のみ出力する (次のreconcileStatisticsで反映される)
+func refreshRankingsAfterCommit(c echo.Context, livestreamIDs ...int64) {
+	if err := refreshRankings(c.Request().Context(), livestreamIDs...); err != nil {
+		c.Logger().Warnf("failed to refresh rankings: %s", err.Error())
+	}
+}
+
+// refreshUserRanking は、コミット済みの統計情報カウンタから、配信者のランキングを更新する
+func refreshUserRanking(ctx context.Context, userID int64) error {
+	return refreshUserRankingWithVersion(ctx, userID, nextRankingVersion())
+}
+
+func refreshUserRankingWithVersion(ctx context.Context, userID int64, version uint64) error {
+	var user struct {
+		Username string `db:"username"`
+		Score    int64  `db:"score"`
+	}
+	if err := dbConn.GetContext(ctx, &user, "SELECT u.name AS username, IFNULL(s.total_reactions + s.total_tip, 0) AS score FROM users u LEFT JOIN user_statistics s ON s.user_id = u.id WHERE u.id = ?", userID); err != nil {
+		return err
+	}
+	userRanking.Set(userID, user.Username, user.Score, version)
+	return nil
+}
+
+var (
+	// userRanking は、配信者のランキング (スコアが同じ場合はユーザ名の辞書順で後ろの方が上位)
+	userRanking = newScoreRanking()
+	// livestreamRanking は、ライブ配信のランキング (スコアが同じ場合はIDが大きい方が上位)
+	// 配信者のランキングと同様に、返金・保留中のチップはスコアに含めない
+	livestreamRanking = newScoreRanking()
+)
+
+// rankingKey は、ランキングの並び順を決めるキー
+// Score, Name, IDの順に比較し、大きいものほど上位とする
+type rankingKey struct {
+	Score int64
+	Name  string
+	ID    int64
+}
+
+func (k rankingKey) less(o rankingKey) bool {
+	if k.Score != o.Score {
+		return k.Score < o.Score
+	}
+	if k.Name != o.Name {
+		return k.Name < o.Name
+	}
+	return k.ID < o.ID
+}
+
+// rankingNode は、部分木のサイズを持つtreapのノード
+type rankingNode struct {
+	key         rankingKey
+	priority    int64
+	size        int
+	left, right *rankingNode
+}
+
+func (n *rankingNode) update() {
+	n.size = 1 + rankingNodeSize(n.left) + rankingNodeSize(n.right)
+}
+
+func rankingNodeSize(n *rankingNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+// splitRankingNode は、keyより小さいものと、key以上のものに分割する
+func splitRankingNode(n *rankingNode, key rankingKey) (*rankingNode, *rankingNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if n.key.less(key) {
+		l, r := splitRankingNode(n.right, key)
+		n.right = l
+		n.update()
+		return n, r
+	}
+	l, r := splitRankingNode(n.left, key)
+	n.left = r
+	n.update()
+	return l, n
+}
+
+func mergeRankingNode(l, r *rankingNode) *rankingNode {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	if l.priority > r.priority {
+		l.right = mergeRankingNode(l.right, r)
+		l.update()
+		return l
+	}
+	r.left = mergeRankingNode(l, r.left)
+	r.update()
+	return r
+}
+
+func removeRankingNode(n *rankingNode, key rankingKey) *rankingNode {
+	if n == nil {
+		return nil
+	}
+	switch {
+	case key.less(n.key):
+		n.left = removeRankingNode(n.left, key)
+	case n.key.less(key):
+		n.right = removeRankingNode(n.right, key)
+	default:
+		return mergeRankingNode(n.left, n.right)
+	}
+	n.update()
+	return n
+}
+
+// scoreRanking は、スコアの順位をO(log n)で求めるためのランキング
+type scoreRanking struct {
+	mu   sync.RWMutex
+	root *rankingNode
+	keys map[int64]rankingKey
+	// versions は、idごとに最後に反映した読み込みのバージョン (削除したidも残す)
+	versions map[int64]uint64
+	rand     *rand.Rand
+}
+
+func newScoreRanking() *scoreRanking {
+	return &scoreRanking{
+		keys:     make(map[int64]rankingKey),
+		versions: make(map[int64]uint64),
+		rand:     rand.New(rand.NewSource(1)),
+	}
+}
+
+func (r *scoreRanking) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.root = nil
+	r.keys = make(map[int64]rankingKey)
+	r.versions = make(map[int64]uint64)
+}
+
+// Set は、idのスコアを設定する
+// versionが既に反映したものより古い場合は何もせず、falseを返す
+func (r *scoreRanking) Set(id int64, name string, score int64, version uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if version < r.versions[id] {
+		return false
+	}
+	r.versions[id] = version
+
+	key := rankingKey{Score: score, Name: name, ID: id}
+	if old, ok := r.keys[id]; ok {
+		if old == key {
+			return true
+		}
+		r.root = removeRankingNode(r.root, old)
+	}
+	r.keys[id] = key
+
+	node := &rankingNode{key: key, priority: r.rand.Int63(), size: 1}
+	l, gte := splitRankingNode(r.root, key)
+	r.root = mergeRankingNode(mergeRankingNode(l, node), gte)
+	return true
+}
+
+// Remove は、idをランキングから取り除く
+// versionが既に反映したものより古い場合は何もせず、falseを返す
+func (r *scoreRanking) Remove(id int64, version uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if version < r.versions[id] {
+		return false
+	}
+	r.versions[id] = version
+
+	if old, ok := r.keys[id]; ok {
+		r.root = removeRankingNode(r.root, old)
+		delete(r.keys, id)
+	}
+	return true
+}
+
+func (r *scoreRanking) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return rankingNodeSize(r.root)
+}
+
+// Rank は、idの順位(1始まり)を返す
+func (r *scoreRanking) Rank(id int64) (int64, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return 0, false
+	}
+
+	// keyより大きいものの数を数える
+	var greater int
+	for n := r.root; n != nil; {
+		if key.less(n.key) {
+			greater += rankingNodeSize(n.right) + 1
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return int64(greater + 1), true
+}
+
+// Range は、offset番目(0始まり)から最大limit件を上位から順に返す
+func (r *scoreRanking) Range(offset, limit int) []rankingKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var keys []rankingKey
+	for i := offset; i < offset+limit && i < rankingNodeSize(r.root); i++ {
+		keys = append(keys, r.nth(i))
+	}
+	return keys
+}
+
+// nth は、上位からi番目(0始まり)のキーを返す
+func (r *scoreRanking) nth(i int) rankingKey {
+	n := r.root
+	for {
+		rs := rankingNodeSize(n.right)
+		switch {
+		case i < rs:
+			n = n.right
+		case i == rs:
+			return n.key
+		default:
+			i -= rs + 1
+			n = n.left
+		}
+	}
+}
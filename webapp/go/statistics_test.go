@@ -0,0 +1,186 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type rankingEntry struct {
+	id    int64
+	name  string
+	score int64
+}
+
+func newTestScoreRanking(entries []rankingEntry) *scoreRanking {
+	r := newScoreRanking()
+	for _, e := range entries {
+		r.Set(e.id, e.name, e.score, 1)
+	}
+	return r
+}
+
+func rankingIDs(keys []rankingKey) []int64 {
+	ids := make([]int64, len(keys))
+	for i, k := range keys {
+		ids[i] = k.ID
+	}
+	return ids
+}
+
+func TestScoreRankingRank(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []rankingEntry
+		// 更新後に設定するエントリ
+		updates []rankingEntry
+		removes []int64
+		// 上位から順に並んだID
+		want []int64
+	}{
+		{
+			name:    "empty",
+			entries: nil,
+			want:    []int64{},
+		},
+		{
+			name: "higher score ranks first",
+			entries: []rankingEntry{
+				{id: 1, name: "a", score: 10},
+				{id: 2, name: "b", score: 30},
+				{id: 3, name: "c", score: 20},
+			},
+			want: []int64{2, 3, 1},
+		},
+		{
+			name: "ties are broken by name in descending order",
+			entries: []rankingEntry{
+				{id: 1, name: "alice", score: 10},
+				{id: 2, name: "carol", score: 10},
+				{id: 3, name: "bob", score: 10},
+			},
+			want: []int64{2, 3, 1},
+		},
+		{
+			name: "ties with the same name are broken by higher id",
+			entries: []rankingEntry{
+				{id: 5, score: 0},
+				{id: 7, score: 0},
+				{id: 6, score: 0},
+			},
+			want: []int64{7, 6, 5},
+		},
+		{
+			name: "rank after update",
+			entries: []rankingEntry{
+				{id: 1, name: "a", score: 10},
+				{id: 2, name: "b", score: 20},
+				{id: 3, name: "c", score: 30},
+			},
+			updates: []rankingEntry{
+				{id: 1, name: "a", score: 40},
+				{id: 3, name: "c", score: 5},
+			},
+			want: []int64{1, 2, 3},
+		},
+		{
+			name: "rank after removal",
+			entries: []rankingEntry{
+				{id: 1, name: "a", score: 10},
+				{id: 2, name: "b", score: 20},
+				{id: 3, name: "c", score: 30},
+			},
+			removes: []int64{2, 4},
+			want:    []int64{3, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestScoreRanking(tt.entries)
+			for _, e := range tt.updates {
+				r.Set(e.id, e.name, e.score, 2)
+			}
+			for _, id := range tt.removes {
+				r.Remove(id, 2)
+			}
+
+			if got := r.Len(); got != len(tt.want) {
+				t.Fatalf("Len() = %d, want %d", got, len(tt.want))
+			}
+			if got := rankingIDs(r.Range(0, r.Len())); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Range() = %v, want %v", got, tt.want)
+			}
+			for i, id := range tt.want {
+				rank, ok := r.Rank(id)
+				if !ok || rank != int64(i+1) {
+					t.Errorf("Rank(%d) = %d, %v, want %d", id, rank, ok, i+1)
+				}
+			}
+			for _, id := range tt.removes {
+				if _, ok := r.Rank(id); ok {
+					t.Errorf("Rank(%d) found removed id", id)
+				}
+			}
+		})
+	}
+}
+
+func TestScoreRankingRange(t *testing.T) {
+	r := newTestScoreRanking([]rankingEntry{
+		{id: 1, score: 10},
+		{id: 2, score: 20},
+		{id: 3, score: 30},
+		{id: 4, score: 40},
+	})
+
+	tests := []struct {
+		name   string
+		offset int
+		limit  int
+		want   []int64
+	}{
+		{name: "first page", offset: 0, limit: 2, want: []int64{4, 3}},
+		{name: "middle", offset: 1, limit: 2, want: []int64{3, 2}},
+		{name: "last page is truncated", offset: 3, limit: 2, want: []int64{1}},
+		{name: "limit covers all", offset: 0, limit: 10, want: []int64{4, 3, 2, 1}},
+		{name: "offset at the end", offset: 4, limit: 2, want: []int64{}},
+		{name: "offset beyond the end", offset: 10, limit: 2, want: []int64{}},
+		{name: "zero limit", offset: 0, limit: 0, want: []int64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rankingIDs(r.Range(tt.offset, tt.limit)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Range(%d, %d) = %v, want %v", tt.offset, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+// 並行して読み込んだ古い統計情報で、新しい値を上書きしないこと
+func TestScoreRankingIgnoresStaleVersion(t *testing.T) {
+	r := newScoreRanking()
+	r.Set(1, "a", 10, 1)
+	r.Set(2, "b", 20, 1)
+
+	if !r.Set(1, "a", 30, 3) {
+		t.Fatalf("Set with newer version was ignored")
+	}
+	if r.Set(1, "a", 15, 2) {
+		t.Fatalf("Set with stale version was applied")
+	}
+	if rank, _ := r.Rank(1); rank != 1 {
+		t.Fatalf("Rank(1) = %d, want 1", rank)
+	}
+
+	// 削除後に、削除前に読み込んだ値で復活させない
+	if !r.Remove(2, 3) {
+		t.Fatalf("Remove with newer version was ignored")
+	}
+	if r.Set(2, "b", 20, 2) {
+		t.Fatalf("Set with stale version resurrected removed id")
+	}
+	if got := r.Len(); got != 1 {
+		t.Fatalf("Len() = %d, want 1", got)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const timelineBucketSeconds = 60 * 60
+
+type LivestreamTimeline struct {
+	LivestreamID int64                       `json:"livestream_id"`
+	Buckets      []*LivestreamTimelineBucket `json:"buckets"`
+}
+
+// LivestreamTimelineBucket は、1時間ごとの集計
+// チップ(返金・保留中のものを除く)・入室・退室は配信者本人にのみ返す
+type LivestreamTimelineBucket struct {
+	StartAt int64 `json:"start_at"`
+	EndAt   int64 `json:"end_at"`
+	// 非表示にされたものを除いたライブコメント数
+	CommentCount int64            `json:"comment_count"`
+	Reactions    map[string]int64 `json:"reactions"`
+	TipSum       *int64           `json:"tip_sum,omitempty"`
+	Entries      *int64           `json:"entries,omitempty"`
+	Exits        *int64           `json:"exits,omitempty"`
+}
+
+// timelineBucketExpr は、columnの時刻が何番目の区間に含まれるかを求める式を返す
+// 配信時間外のものは、最初・最後の区間に含める
+func timelineBucketExpr(column string, startAt int64, buckets int) (string, []interface{}) {
+	return "LEAST(GREATEST(FLOOR((" + column + " - ?) / ?), 0), ?)", []interface{}{startAt, timelineBucketSeconds, buckets - 1}
+}
+
+// countTimelineBuckets は、区間ごとの件数を数える
+// queryは、区間の番号をbucket、件数をcountとして返すこと
+func countTimelineBuckets(ctx context.Context, tx *sqlx.Tx, counts []int64, query string, args ...interface{}) error {
+	var rows []struct {
+		Bucket int   `db:"bucket"`
+		Count  int64 `db:"count"`
+	}
+	if err := tx.SelectContext(ctx, &rows, query, args...); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		counts[row.Bucket] = row.Count
+	}
+	return nil
+}
+
+// ライブ配信の時間ごとの統計情報取得API
+// GET /api/livestream/:livestream_id/statistics/timeline
+func getLivestreamTimelineHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	var livestreamModel LivestreamModel
+	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+	isOwner := livestreamModel.UserID == userID
+
+	// 区間は正時から始める
+	startAt := livestreamModel.StartAt - livestreamModel.StartAt%timelineBucketSeconds
+	n := int((livestreamModel.EndAt - startAt + timelineBucketSeconds - 1) / timelineBucketSeconds)
+	if n < 1 {
+		n = 1
+	}
+	buckets := make([]*LivestreamTimelineBucket, n)
+	for i := range buckets {
+		bucketStartAt := startAt + int64(i)*timelineBucketSeconds
+		buckets[i] = &LivestreamTimelineBucket{
+			StartAt:   bucketStartAt,
+			EndAt:     bucketStartAt + timelineBucketSeconds,
+			Reactions: map[string]int64{},
+		}
+	}
+
+	commentCounts := make([]int64, n)
+	expr, args := timelineBucketExpr("created_at", startAt, n)
+	if err := countTimelineBuckets(ctx, tx, commentCounts, "SELECT "+expr+" AS bucket, COUNT(*) AS count FROM livecomments WHERE livestream_id = ? AND hidden_at = 0 GROUP BY bucket", append(args, livestreamModel.ID)...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to count livecomments: "+err.Error())
+	}
+
+	var reactions []struct {
+		Bucket    int    `db:"bucket"`
+		EmojiName string `db:"emoji_name"`
+		Count     int64  `db:"count"`
+	}
+	if err := tx.SelectContext(ctx, &reactions, "SELECT "+expr+" AS bucket, emoji_name, COUNT(*) AS count FROM reactions WHERE livestream_id = ? GROUP BY bucket, emoji_name", append(args, livestreamModel.ID)...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to count reactions: "+err.Error())
+	}
+
+	for i := range buckets {
+		buckets[i].CommentCount = commentCounts[i]
+	}
+	for _, r := range reactions {
+		buckets[r.Bucket].Reactions[r.EmojiName] = r.Count
+	}
+
+	if isOwner {
+		// 売上と同様に、返金・保留中のチップは差し引く
+		tipSums := make([]int64, n)
+		expr, args := timelineBucketExpr("t.created_at", startAt, n)
+		if err := countTimelineBuckets(ctx, tx, tipSums, "SELECT "+expr+" AS bucket, SUM(t.amount - IFNULL(r.amount, 0)) AS count FROM "+tipsWithRefundsJoin+" WHERE t.livestream_id = ? GROUP BY bucket", append(args, livestreamModel.ID)...); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to sum tips: "+err.Error())
+		}
+
+		entries := make([]int64, n)
+		expr, args = timelineBucketExpr("entered_at", startAt, n)
+		if err := countTimelineBuckets(ctx, tx, entries, "SELECT "+expr+" AS bucket, COUNT(*) AS count FROM livestream_viewer_sessions WHERE livestream_id = ? GROUP BY bucket", append(args, livestreamModel.ID)...); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to count entries: "+err.Error())
+		}
+
+		exits := make([]int64, n)
+		expr, args = timelineBucketExpr("exited_at", startAt, n)
+		if err := countTimelineBuckets(ctx, tx, exits, "SELECT "+expr+" AS bucket, COUNT(*) AS count FROM livestream_viewer_sessions WHERE livestream_id = ? AND exited_at != 0 GROUP BY bucket", append(args, livestreamModel.ID)...); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to count exits: "+err.Error())
+		}
+
+		for i := range buckets {
+			buckets[i].TipSum = &tipSums[i]
+			buckets[i].Entries = &entries[i]
+			buckets[i].Exits = &exits[i]
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, LivestreamTimeline{
+		LivestreamID: livestreamModel.ID,
+		Buckets:      buckets,
+	})
+}
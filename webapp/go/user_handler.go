@@ -2,14 +2,15 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,9 +27,22 @@ const (
 	defaultUserIDKey         = "USERID"
 	defaultUsernameKey       = "USERNAME"
 	bcryptDefaultCost        = bcrypt.MinCost
+	bcryptCostEnvKey         = "ISUCON13_BCRYPT_COST"
 )
 
-var fallbackImage = "../img/NoImage.jpg"
+// bcryptCost は、パスワードのハッシュ化に用いるコスト
+// ログイン時、これより低いコストでハッシュ化されていたパスワードは再ハッシュする
+var bcryptCost = bcryptDefaultCost
+
+func init() {
+	if v, ok := os.LookupEnv(bcryptCostEnvKey); ok {
+		cost, err := strconv.Atoi(v)
+		if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			log.Fatalf("environment variable '%s' must be integer between %d and %d", bcryptCostEnvKey, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		bcryptCost = cost
+	}
+}
 
 type UserModel struct {
 	ID             int64  `db:"id"`
@@ -45,6 +59,8 @@ type User struct {
 	Description string `json:"description,omitempty"`
 	Theme       Theme  `json:"theme,omitempty"`
 	IconHash    string `json:"icon_hash,omitempty"`
+	// FollowersCount は、このユーザをフォローしているユーザ数
+	FollowersCount int64 `json:"followers_count"`
 }
 
 type Theme struct {
@@ -71,12 +87,30 @@ type PostUserRequestTheme struct {
 	DarkMode bool `json:"dark_mode"`
 }
 
+// PatchUserRequest は、プロフィールとテーマの部分更新リクエスト
+// 指定されなかったフィールドは更新しない
+type PatchUserRequest struct {
+	DisplayName *string                `json:"display_name"`
+	Description *string                `json:"description"`
+	Theme       *PatchUserRequestTheme `json:"theme"`
+}
+
+type PatchUserRequestTheme struct {
+	DarkMode *bool `json:"dark_mode"`
+}
+
 type LoginRequest struct {
 	Username string `json:"username"`
 	// Password is non-hashed password.
 	Password string `json:"password"`
 }
 
+type ChangePasswordRequest struct {
+	// CurrentPassword, NewPassword are non-hashed passwords.
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
 type PostIconRequest struct {
 	Image []byte `json:"image"`
 }
@@ -104,8 +138,52 @@ func getIconHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
 	}
 
-	var image []byte
-	if err := tx.GetContext(ctx, &image, "SELECT image FROM icons WHERE user_id = ?", user.ID); err != nil {
+	size := 0
+	if v := c.QueryParam("size"); v != "" {
+		size, err = strconv.Atoi(v)
+		if err != nil || !isValidIconThumbnailSize(size) {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("size query parameter must be one of %v", iconThumbnailSizes))
+		}
+	}
+
+	iconHash, err := getIconHash(ctx, tx, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user icon hash: "+err.Error())
+	}
+
+	// アイコンはユーザ名ごとのURLで変更されうるので、毎回ETagで再検証させる
+	// サムネイルは元画像とは別の表現なので、ETagにサイズを含める
+	etag := `"` + iconHash + `"`
+	if size != 0 {
+		etag = fmt.Sprintf(`"%s-%d"`, iconHash, size)
+	}
+	c.Response().Header().Set("ETag", etag)
+	c.Response().Header().Set("Cache-Control", "public, no-cache")
+	if matchesETag(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	if size != 0 {
+		var thumb IconThumbnailModel
+		if err := tx.GetContext(ctx, &thumb, "SELECT * FROM icon_thumbnails WHERE user_id = ? AND size = ?", user.ID, size); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user icon thumbnail: "+err.Error())
+			}
+			_, fallbackThumbs, err := loadFallbackIcon()
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to load fallback icon: "+err.Error())
+			}
+			for _, t := range fallbackThumbs {
+				if t.Size == size {
+					thumb = *t
+				}
+			}
+		}
+		return c.Blob(http.StatusOK, thumb.ContentType, thumb.Image)
+	}
+
+	var icon IconModel
+	if err := tx.GetContext(ctx, &icon, "SELECT * FROM icons WHERE user_id = ?", user.ID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return c.File(fallbackImage)
 		} else {
@@ -113,7 +191,7 @@ func getIconHandler(c echo.Context) error {
 		}
 	}
 
-	return c.Blob(http.StatusOK, "image/jpeg", image)
+	return c.Blob(http.StatusOK, icon.ContentType, icon.Image)
 }
 
 func postIconHandler(c echo.Context) error {
@@ -134,6 +212,15 @@ func postIconHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
+	icon, thumbs, err := normalizeIcon(req.Image)
+	if err != nil {
+		if errors.Is(err, errInvalidIcon) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to normalize icon: "+err.Error())
+	}
+	icon.UserID = userID
+
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
@@ -143,11 +230,21 @@ func postIconHandler(c echo.Context) error {
 	if _, err := tx.ExecContext(ctx, "DELETE FROM icons WHERE user_id = ?", userID); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old user icon: "+err.Error())
 	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM icon_thumbnails WHERE user_id = ?", userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old user icon thumbnails: "+err.Error())
+	}
 
-	rs, err := tx.ExecContext(ctx, "INSERT INTO icons (user_id, image) VALUES (?, ?)", userID, req.Image)
+	// 表示のたびにハッシュを計算しなくて済むよう、アイコンと合わせて保存する
+	rs, err := tx.NamedExecContext(ctx, "INSERT INTO icons (user_id, image, icon_hash, content_type) VALUES (:user_id, :image, :icon_hash, :content_type)", icon)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert new user icon: "+err.Error())
 	}
+	for _, thumb := range thumbs {
+		thumb.UserID = userID
+		if _, err := tx.NamedExecContext(ctx, "INSERT INTO icon_thumbnails (user_id, size, image, content_type) VALUES (:user_id, :size, :image, :content_type)", thumb); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert new user icon thumbnail: "+err.Error())
+		}
+	}
 
 	iconID, err := rs.LastInsertId()
 	if err != nil {
@@ -203,6 +300,70 @@ func getMeHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, user)
 }
 
+// プロフィール更新API
+// PATCH /api/user/me
+func patchMeHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *PatchUserRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	userModel := UserModel{}
+	err = tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ? FOR UPDATE", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "not found user that has the userid in session")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+
+	if req.DisplayName != nil {
+		userModel.DisplayName = *req.DisplayName
+	}
+	if req.Description != nil {
+		userModel.Description = *req.Description
+	}
+	if _, err := tx.NamedExecContext(ctx, "UPDATE users SET display_name = :display_name, description = :description WHERE id = :id", userModel); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update user: "+err.Error())
+	}
+
+	if req.Theme != nil && req.Theme.DarkMode != nil {
+		if _, err := tx.ExecContext(ctx, "UPDATE themes SET dark_mode = ? WHERE user_id = ?", *req.Theme.DarkMode, userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update user theme: "+err.Error())
+		}
+	}
+
+	user, err := fillUserResponse(ctx, tx, userModel)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
 // ユーザ登録API
 // POST /api/register
 func registerHandler(c echo.Context) error {
@@ -218,7 +379,7 @@ func registerHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "the username 'pipe' is reserved")
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptDefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate hashed password: "+err.Error())
 	}
@@ -260,6 +421,11 @@ func registerHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, string(out)+": "+err.Error())
 	}
 
+	// 統計情報を0件で作成しておく
+	if _, err := tx.ExecContext(ctx, "INSERT INTO user_statistics (user_id) VALUES (?)", userModel.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user statistics: "+err.Error())
+	}
+
 	user, err := fillUserResponse(ctx, tx, userModel)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
@@ -268,6 +434,9 @@ func registerHandler(c echo.Context) error {
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
 	}
+	if err := refreshUserRanking(ctx, userModel.ID); err != nil {
+		c.Logger().Warnf("failed to refresh rankings: %s", err.Error())
+	}
 
 	return c.JSON(http.StatusCreated, user)
 }
@@ -283,6 +452,17 @@ func loginHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
+	// 総当たり攻撃を防ぐため、失敗が続いたユーザ名でのログインは一定時間拒否する
+	now := time.Now()
+	usernameKey := loginThrottleUsernameKey(req.Username)
+	if retryAfter, locked := loginAttemptThrottler.RetryAfter(now, usernameKey); locked {
+		return newTooManyRequestsError(c, retryAfter, "too many failed login attempts")
+	}
+	loginFailed := func() error {
+		loginAttemptThrottler.Fail(now, usernameKey, loginUsernameFailureThreshold)
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid username or password")
+	}
+
 	tx, err := dbConn.BeginTxx(ctx, nil)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
@@ -293,7 +473,7 @@ func loginHandler(c echo.Context) error {
 	// usernameはUNIQUEなので、whereで一意に特定できる
 	err = tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", req.Username)
 	if errors.Is(err, sql.ErrNoRows) {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid username or password")
+		return loginFailed()
 	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
@@ -305,13 +485,22 @@ func loginHandler(c echo.Context) error {
 
 	err = bcrypt.CompareHashAndPassword([]byte(userModel.HashedPassword), []byte(req.Password))
 	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid username or password")
+		return loginFailed()
 	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to compare hash and password: "+err.Error())
 	}
+	loginAttemptThrottler.Succeed(usernameKey)
 
-	sessionEndAt := time.Now().Add(1 * time.Hour)
+	// 設定より低いコストでハッシュ化されていたパスワードは、平文が手元にあるこの時点で再ハッシュする
+	if cost, err := bcrypt.Cost([]byte(userModel.HashedPassword)); err == nil && cost < bcryptCost {
+		if err := rehashPassword(ctx, userModel.ID, req.Password); err != nil {
+			// ログイン自体は成功しているので、失敗しても次回のログイン時に再試行すれば良い
+			c.Logger().Warnf("failed to rehash password: %+v", err)
+		}
+	}
+
+	sessionEndAt := now.Add(1 * time.Hour)
 
 	sessionID := uuid.NewString()
 
@@ -320,6 +509,15 @@ func loginHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "failed to get session")
 	}
 
+	if err := sessionStore.Create(ctx, &SessionModel{
+		ID:        sessionID,
+		UserID:    userModel.ID,
+		ExpiresAt: sessionEndAt.Unix(),
+		CreatedAt: now.Unix(),
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create session: "+err.Error())
+	}
+
 	sess.Options = &sessions.Options{
 		Domain: "u.isucon.dev",
 		MaxAge: int(60000),
@@ -337,6 +535,148 @@ func loginHandler(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
+func rehashPassword(ctx context.Context, userID int64, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbConn.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", string(hashedPassword), userID)
+	return err
+}
+
+// パスワード変更API
+// POST /api/user/me/password
+func changePasswordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	req := ChangePasswordRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.NewPassword == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "new_password must not be empty")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	userModel := UserModel{}
+	err = tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ? FOR UPDATE", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "not found user that has the userid in session")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(userModel.HashedPassword), []byte(req.CurrentPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return echo.NewHTTPError(http.StatusForbidden, "current password is wrong")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to compare hash and password: "+err.Error())
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcryptCost)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate hashed password: "+err.Error())
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", string(hashedPassword), userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update password: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	}
+
+	// 漏洩した可能性のあるセッションを含め、既存のセッションは全て無効にする
+	if err := sessionStore.DeleteByUserID(ctx, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete sessions: "+err.Error())
+	}
+	if err := expireSessionCookie(c, sess); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save session: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// ログアウトAPI
+// POST /api/logout
+func logoutHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	sessionID := sess.Values[defaultSessionIDKey].(string)
+
+	if err := sessionStore.Delete(ctx, sessionID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete session: "+err.Error())
+	}
+
+	if err := expireSessionCookie(c, sess); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save session: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// 全端末からのログアウトAPI
+// POST /api/logout/all
+func logoutAllHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	if err := sessionStore.DeleteByUserID(ctx, userID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete sessions: "+err.Error())
+	}
+
+	if err := expireSessionCookie(c, sess); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save session: "+err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func expireSessionCookie(c echo.Context, sess *sessions.Session) error {
+	sess.Options = &sessions.Options{
+		Domain: "u.isucon.dev",
+		MaxAge: -1,
+		Path:   "/",
+	}
+	return sess.Save(c.Request(), c.Response())
+}
+
 // ユーザ詳細API
 // GET /api/user/:username
 func getUserHandler(c echo.Context) error {
@@ -385,7 +725,7 @@ func verifyUserSession(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusForbidden, "failed to get EXPIRES value from session")
 	}
 
-	_, ok = sess.Values[defaultUserIDKey].(int64)
+	userID, ok := sess.Values[defaultUserIDKey].(int64)
 	if !ok {
 		return echo.NewHTTPError(http.StatusUnauthorized, "failed to get USERID value from session")
 	}
@@ -395,6 +735,30 @@ func verifyUserSession(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "session has expired")
 	}
 
+	sessionID, ok := sess.Values[defaultSessionIDKey].(string)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "failed to get SESSIONID value from session")
+	}
+
+	// ログアウトなどで失効したセッションでないか、サーバ側のセッションを確認する
+	ctx := c.Request().Context()
+	storedSession, err := sessionStore.Get(ctx, sessionID)
+	if errors.Is(err, errSessionNotFound) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "session has been revoked")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get session: "+err.Error())
+	}
+	if storedSession.UserID != userID {
+		return echo.NewHTTPError(http.StatusUnauthorized, "session has been revoked")
+	}
+	if now.Unix() > storedSession.ExpiresAt {
+		if err := sessionStore.Delete(ctx, sessionID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete session: "+err.Error())
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized, "session has expired")
+	}
+
 	return nil
 }
 
@@ -404,17 +768,15 @@ func fillUserResponse(ctx context.Context, tx *sqlx.Tx, userModel UserModel) (Us
 		return User{}, err
 	}
 
-	var image []byte
-	if err := tx.GetContext(ctx, &image, "SELECT image FROM icons WHERE user_id = ?", userModel.ID); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			return User{}, err
-		}
-		image, err = os.ReadFile(fallbackImage)
-		if err != nil {
-			return User{}, err
-		}
+	iconHash, err := getIconHash(ctx, tx, userModel.ID)
+	if err != nil {
+		return User{}, err
+	}
+
+	var followersCount int64
+	if err := tx.GetContext(ctx, &followersCount, "SELECT COUNT(*) FROM follows WHERE followee_id = ?", userModel.ID); err != nil {
+		return User{}, err
 	}
-	iconHash := sha256.Sum256(image)
 
 	user := User{
 		ID:          userModel.ID,
@@ -425,7 +787,8 @@ func fillUserResponse(ctx context.Context, tx *sqlx.Tx, userModel UserModel) (Us
 			ID:       themeModel.ID,
 			DarkMode: themeModel.DarkMode,
 		},
-		IconHash: fmt.Sprintf("%x", iconHash),
+		IconHash:       iconHash,
+		FollowersCount: followersCount,
 	}
 
 	return user, nil
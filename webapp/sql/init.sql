@@ -1,5 +1,6 @@
 TRUNCATE TABLE themes;
 TRUNCATE TABLE icons;
+TRUNCATE TABLE icon_thumbnails;
 TRUNCATE TABLE reservation_slots;
 TRUNCATE TABLE livestream_viewers_history;
 TRUNCATE TABLE livecomment_reports;
@@ -10,6 +11,22 @@ TRUNCATE TABLE livestream_tags;
 TRUNCATE TABLE livecomments;
 TRUNCATE TABLE livestreams;
 TRUNCATE TABLE users;
+TRUNCATE TABLE sessions;
+TRUNCATE TABLE follows;
+TRUNCATE TABLE livestream_collaborators;
+TRUNCATE TABLE moderation_logs;
+TRUNCATE TABLE livestream_moderation_settings;
+TRUNCATE TABLE user_bans;
+TRUNCATE TABLE tips;
+TRUNCATE TABLE tip_shares;
+TRUNCATE TABLE idempotency_keys;
+TRUNCATE TABLE payment_settings;
+TRUNCATE TABLE refunds;
+TRUNCATE TABLE livestream_statistics;
+TRUNCATE TABLE user_statistics;
+TRUNCATE TABLE livestream_viewer_sessions;
+TRUNCATE TABLE livestream_unique_viewers;
+TRUNCATE TABLE livestream_presence;
 
 ALTER TABLE `themes` auto_increment = 1;
 ALTER TABLE `icons` auto_increment = 1;
@@ -22,4 +39,12 @@ ALTER TABLE `reactions` auto_increment = 1;
 ALTER TABLE `tags` auto_increment = 1;
 ALTER TABLE `livecomments` auto_increment = 1;
 ALTER TABLE `livestreams` auto_increment = 1;
-ALTER TABLE `users` auto_increment = 1;
\ No newline at end of file
+ALTER TABLE `users` auto_increment = 1;
+ALTER TABLE `follows` auto_increment = 1;
+ALTER TABLE `livestream_collaborators` auto_increment = 1;
+ALTER TABLE `moderation_logs` auto_increment = 1;
+ALTER TABLE `user_bans` auto_increment = 1;
+ALTER TABLE `tips` auto_increment = 1;
+ALTER TABLE `idempotency_keys` auto_increment = 1;
+ALTER TABLE `refunds` auto_increment = 1;
+ALTER TABLE `livestream_viewer_sessions` auto_increment = 1;
\ No newline at end of file
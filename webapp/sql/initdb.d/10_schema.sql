@@ -14,7 +14,22 @@ CREATE TABLE `users` (
 CREATE TABLE `icons` (
   `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
   `user_id` BIGINT NOT NULL,
-  `image` LONGBLOB NOT NULL
+  `image` LONGBLOB NOT NULL,
+  -- imageのSHA-256 (16進数)
+  `icon_hash` VARCHAR(64) NOT NULL,
+  -- image/jpeg, image/png, image/gif のいずれか
+  `content_type` VARCHAR(255) NOT NULL,
+  INDEX `icons_user_id` (`user_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- ユーザのアイコンを正方形に縮小したもの
+CREATE TABLE `icon_thumbnails` (
+  `user_id` BIGINT NOT NULL,
+  -- 一辺の長さ (64, 128, 256 のいずれか)
+  `size` INT NOT NULL,
+  `image` MEDIUMBLOB NOT NULL,
+  `content_type` VARCHAR(255) NOT NULL,
+  PRIMARY KEY (`user_id`, `size`)
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 
 -- ユーザごとのカスタムテーマ
@@ -73,6 +88,11 @@ CREATE TABLE `livecomments` (
   `livestream_id` BIGINT NOT NULL,
   `comment` VARCHAR(255) NOT NULL,
   `tip` BIGINT NOT NULL DEFAULT 0,
+  -- moderationにより非表示にされた日時。0の場合は表示中
+  `hidden_at` BIGINT NOT NULL DEFAULT 0,
+  `hidden_reason` VARCHAR(255) NOT NULL DEFAULT '',
+  -- 非表示のきっかけとなったNGワード
+  `hidden_by_ng_word_id` BIGINT NOT NULL DEFAULT 0,
   `created_at` BIGINT NOT NULL
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 
@@ -82,15 +102,22 @@ CREATE TABLE `livecomment_reports` (
   `user_id` BIGINT NOT NULL,
   `livestream_id` BIGINT NOT NULL,
   `livecomment_id` BIGINT NOT NULL,
-  `created_at` BIGINT NOT NULL
+  -- open, dismissed, actioned のいずれか
+  `status` VARCHAR(255) NOT NULL DEFAULT 'open',
+  `resolved_at` BIGINT NOT NULL DEFAULT 0,
+  `created_at` BIGINT NOT NULL,
+  UNIQUE `uniq_livecomment_reporter` (`livecomment_id`, `user_id`)
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 
 -- 配信者からのNGワード登録
 CREATE TABLE `ng_words` (
   `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
   `user_id` BIGINT NOT NULL,
+  -- 0の場合は、配信者の全ての配信に適用するアカウント単位のNGワード
   `livestream_id` BIGINT NOT NULL,
   `word` VARCHAR(255) NOT NULL,
+  -- substring, glob, regex のいずれか
+  `match_type` VARCHAR(255) NOT NULL DEFAULT 'substring',
   `created_at` BIGINT NOT NULL
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 CREATE INDEX ng_words_word ON ng_words(`word`);
@@ -103,4 +130,210 @@ CREATE TABLE `reactions` (
   -- :innocent:, :tada:, etc...
   `emoji_name` VARCHAR(255) NOT NULL,
   `created_at` BIGINT NOT NULL
-) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
\ No newline at end of file
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+-- ログインセッション
+CREATE TABLE `sessions` (
+  `id` VARCHAR(255) NOT NULL PRIMARY KEY,
+  `user_id` BIGINT NOT NULL,
+  `expires_at` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  INDEX `sessions_user_id` (`user_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- 視聴者による配信者のフォロー
+CREATE TABLE `follows` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `follower_id` BIGINT NOT NULL,
+  `followee_id` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  UNIQUE `uniq_follow` (`follower_id`, `followee_id`),
+  INDEX `follows_followee_id` (`followee_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- ライブ配信のコラボレーター
+CREATE TABLE `livestream_collaborators` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `livestream_id` BIGINT NOT NULL,
+  `user_id` BIGINT NOT NULL,
+  -- pending, accepted, declined
+  `status` VARCHAR(255) NOT NULL,
+  `tip_share_percent` BIGINT NOT NULL DEFAULT 0,
+  `created_at` BIGINT NOT NULL,
+  `updated_at` BIGINT NOT NULL,
+  UNIQUE `uniq_livestream_collaborator` (`livestream_id`, `user_id`),
+  INDEX `livestream_collaborators_user_id` (`user_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- moderation操作の監査ログ
+CREATE TABLE `moderation_logs` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  -- アカウント単位のNGワードに対する操作の場合は0
+  `livestream_id` BIGINT NOT NULL,
+  -- 操作したユーザ
+  `user_id` BIGINT NOT NULL,
+  -- add_ng_word, delete_ng_word, hide_livecomment, restore_livecomment, dismiss_report, ban_user, timeout_user, unban_user
+  `action` VARCHAR(255) NOT NULL,
+  `livecomment_id` BIGINT NOT NULL DEFAULT 0,
+  `ng_word_id` BIGINT NOT NULL DEFAULT 0,
+  -- BANなど、ユーザに対する操作の対象ユーザ
+  `target_user_id` BIGINT NOT NULL DEFAULT 0,
+  `reason` VARCHAR(255) NOT NULL DEFAULT '',
+  `created_at` BIGINT NOT NULL
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+CREATE INDEX moderation_logs_livestream_id ON moderation_logs(`livestream_id`, `id`);
+
+-- ライブ配信ごとのmoderation設定
+CREATE TABLE `livestream_moderation_settings` (
+  `livestream_id` BIGINT NOT NULL PRIMARY KEY,
+  -- この人数のユーザから報告されたライブコメントを自動で非表示にする。0の場合は無効
+  `report_hide_threshold` BIGINT NOT NULL DEFAULT 0,
+  -- 同じユーザがライブコメントを投稿できる最小間隔(秒)。0の場合は無効
+  `slow_mode_interval_seconds` BIGINT NOT NULL DEFAULT 0
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- 配信者のチャンネル(全ての配信)に対するユーザのBAN
+CREATE TABLE `user_bans` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `streamer_id` BIGINT NOT NULL,
+  `user_id` BIGINT NOT NULL,
+  -- BANを行った配信
+  `livestream_id` BIGINT NOT NULL,
+  `reason` VARCHAR(255) NOT NULL DEFAULT '',
+  -- 0の場合は無期限のBAN、それ以外は期限付きのタイムアウト
+  `expires_at` BIGINT NOT NULL DEFAULT 0,
+  `created_by` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  UNIQUE `uniq_streamer_user` (`streamer_id`, `user_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- チップの台帳
+-- ライブコメントが削除・非表示にされても、支払いの記録として残す
+CREATE TABLE `tips` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `livecomment_id` BIGINT NOT NULL,
+  `livestream_id` BIGINT NOT NULL,
+  -- チップを支払ったユーザ
+  `user_id` BIGINT NOT NULL,
+  -- チップを受け取った配信者
+  `streamer_id` BIGINT NOT NULL,
+  `amount` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  UNIQUE `uniq_tip_livecomment` (`livecomment_id`),
+  INDEX `tips_streamer_id_created_at` (`streamer_id`, `created_at`),
+  INDEX `tips_user_id` (`user_id`, `id`),
+  INDEX `tips_livestream_id` (`livestream_id`),
+  INDEX `tips_created_at` (`created_at`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- チップを受け取った時点での、コラボレーターへの分配率
+-- 残りは配信者の取り分
+CREATE TABLE `tip_shares` (
+  `tip_id` BIGINT NOT NULL,
+  `user_id` BIGINT NOT NULL,
+  `share_percent` BIGINT NOT NULL,
+  PRIMARY KEY (`tip_id`, `user_id`),
+  INDEX `tip_shares_user_id` (`user_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- ライブコメント・リアクション投稿のIdempotency-Keyと、それに対して返したレスポンス
+CREATE TABLE `idempotency_keys` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `user_id` BIGINT NOT NULL,
+  `idempotency_key` VARCHAR(255) NOT NULL,
+  -- METHOD PATH
+  `endpoint` VARCHAR(255) NOT NULL,
+  -- リクエストボディのSHA-256
+  `request_hash` VARCHAR(64) NOT NULL,
+  `status_code` INT NOT NULL,
+  `response_body` TEXT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  UNIQUE `uniq_idempotency_key` (`user_id`, `idempotency_key`),
+  INDEX `idempotency_keys_created_at` (`created_at`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- 配信者ごとの売上に関する設定
+CREATE TABLE `payment_settings` (
+  `user_id` BIGINT NOT NULL PRIMARY KEY,
+  -- moderationされたライブコメントのチップの扱い。keep, refund, escrow のいずれか
+  `refund_policy` VARCHAR(255) NOT NULL DEFAULT 'keep'
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- moderationされたライブコメントのチップの返金・保留
+CREATE TABLE `refunds` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `tip_id` BIGINT NOT NULL,
+  `livecomment_id` BIGINT NOT NULL,
+  `livestream_id` BIGINT NOT NULL,
+  -- 返金先の視聴者
+  `user_id` BIGINT NOT NULL,
+  `streamer_id` BIGINT NOT NULL,
+  `amount` BIGINT NOT NULL,
+  -- refunded, escrowed, released のいずれか
+  `status` VARCHAR(255) NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  `resolved_at` BIGINT NOT NULL DEFAULT 0,
+  UNIQUE `uniq_refund_tip` (`tip_id`),
+  INDEX `refunds_livecomment_id` (`livecomment_id`),
+  INDEX `refunds_streamer_id` (`streamer_id`, `id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- ライブ配信ごとの統計情報 (書き込み時に更新する)
+CREATE TABLE `livestream_statistics` (
+  `livestream_id` BIGINT NOT NULL PRIMARY KEY,
+  `user_id` BIGINT NOT NULL,
+  `viewers_count` BIGINT NOT NULL DEFAULT 0,
+  -- 一度でも視聴したことのあるユーザ数
+  `unique_viewers` BIGINT NOT NULL DEFAULT 0,
+  `total_reactions` BIGINT NOT NULL DEFAULT 0,
+  -- 非表示にされたものを除く
+  `total_livecomments` BIGINT NOT NULL DEFAULT 0,
+  -- 非表示にされたものも含めたチップの合計
+  `total_tip` BIGINT NOT NULL DEFAULT 0,
+  -- 返金・保留中のものを除いたチップの合計
+  `net_tip` BIGINT NOT NULL DEFAULT 0,
+  `max_tip` BIGINT NOT NULL DEFAULT 0,
+  -- 未対応のスパム報告数
+  `total_reports` BIGINT NOT NULL DEFAULT 0,
+  INDEX `livestream_statistics_user_id` (`user_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- 配信者ごとの統計情報 (書き込み時に更新する)
+CREATE TABLE `user_statistics` (
+  `user_id` BIGINT NOT NULL PRIMARY KEY,
+  `viewers_count` BIGINT NOT NULL DEFAULT 0,
+  `total_reactions` BIGINT NOT NULL DEFAULT 0,
+  `total_livecomments` BIGINT NOT NULL DEFAULT 0,
+  -- 返金・保留中のものを除いたチップの合計
+  `total_tip` BIGINT NOT NULL DEFAULT 0
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- ライブ配信の視聴記録 (退出しても削除しない)
+CREATE TABLE `livestream_viewer_sessions` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `user_id` BIGINT NOT NULL,
+  `livestream_id` BIGINT NOT NULL,
+  `entered_at` BIGINT NOT NULL,
+  -- 0の場合は視聴中
+  `exited_at` BIGINT NOT NULL DEFAULT 0,
+  INDEX `livestream_viewer_sessions_livestream_id` (`livestream_id`, `entered_at`),
+  INDEX `livestream_viewer_sessions_user_id` (`user_id`, `livestream_id`, `exited_at`),
+  INDEX `livestream_viewer_sessions_user_id_id` (`user_id`, `id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- ライブ配信を一度でも視聴したことのあるユーザ (ユニーク視聴者数の判定に用いる)
+CREATE TABLE `livestream_unique_viewers` (
+  `livestream_id` BIGINT NOT NULL,
+  `user_id` BIGINT NOT NULL,
+  -- 初めて視聴した日時
+  `created_at` BIGINT NOT NULL,
+  PRIMARY KEY (`livestream_id`, `user_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+-- ライブ配信ごとの同時視聴者数 (ハートビートを元に定期的に書き出す)
+CREATE TABLE `livestream_presence` (
+  `livestream_id` BIGINT NOT NULL PRIMARY KEY,
+  `current_viewers` BIGINT NOT NULL DEFAULT 0,
+  `peak_viewers` BIGINT NOT NULL DEFAULT 0,
+  `peak_at` BIGINT NOT NULL DEFAULT 0,
+  `updated_at` BIGINT NOT NULL
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;